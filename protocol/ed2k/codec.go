@@ -0,0 +1,148 @@
+package ed2k
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Message is implemented by every eD2k protocol message type in this
+// package: it can serialize itself to and parse itself from a wire frame,
+// and report its own message-type byte.
+type Message interface {
+	Encode() ([]byte, error)
+	Decode(data []byte) error
+	Type() uint8
+	String() string
+}
+
+// CompressionAware is implemented by message types whose Encode/Decode
+// optionally route their body through zlib. Codec uses it to hand such
+// messages the connection's negotiated CompressionState before encoding.
+type CompressionAware interface {
+	SetCompression(*CompressionState)
+}
+
+// MaxMessageSize bounds the size field read from a frame header, guarding
+// against a corrupt or hostile peer claiming an unreasonably large message.
+const MaxMessageSize = 64 << 20 // 64MiB
+
+var messageRegistry = map[uint8]func() Message{}
+
+// RegisterMessage associates a message-type byte with a constructor so
+// Codec.ReadMessage can build the right concrete type for an incoming
+// frame. Message types in this package register themselves from an init().
+func RegisterMessage(typeByte uint8, newMessage func() Message) {
+	messageRegistry[typeByte] = newMessage
+}
+
+func init() {
+	RegisterMessage(MessageLoginRequest, func() Message { return &LoginMessage{} })
+	RegisterMessage(MessageServerMessage, func() Message { return &ServerMessage{} })
+	RegisterMessage(MessageIDChange, func() Message { return &IDChangeMessage{} })
+	RegisterMessage(MessageOfferFiles, func() Message { return &OfferFilesMessage{} })
+	RegisterMessage(MessageGetServerList, func() Message { return &GetServerListMessage{} })
+	RegisterMessage(MessageServerList, func() Message { return &ServerListMessage{} })
+	RegisterMessage(MessageServerStatus, func() Message { return &ServerStatusMessage{} })
+	RegisterMessage(MessageServerIdent, func() Message { return &ServerIdentMessage{} })
+	RegisterMessage(MessageSearchRequest, func() Message { return &SearchRequestMessage{} })
+	RegisterMessage(MessageSearchResult, func() Message { return &SearchResultMessage{} })
+}
+
+// Codec owns a net.Conn plus buffered reader/writer and frames eD2k
+// messages on and off the wire. It transparently decompresses incoming
+// frames and compresses outgoing ones for CompressionAware message types
+// once compression has been negotiated, so callers never see compressed
+// bytes.
+type Codec struct {
+	conn        net.Conn
+	r           *bufio.Reader
+	w           *bufio.Writer
+	Compression CompressionState
+
+	// Tracer, when set, observes every message this Codec encodes or
+	// decodes. When nil, DefaultTracer is used instead, if any.
+	Tracer Tracer
+}
+
+// NewCodec wraps conn for framed eD2k message reading and writing.
+func NewCodec(conn net.Conn) *Codec {
+	return &Codec{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+}
+
+// ReadMessage reads one framed message from the connection, decompressing
+// it if needed, dispatches on its message-type byte via the registry built
+// by RegisterMessage, and returns the decoded concrete Message.
+func (c *Codec) ReadMessage() (Message, error) {
+	head := make([]byte, HeaderLength)
+	if _, err := io.ReadFull(c.r, head); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(head[1:5])
+	if size == 0 {
+		return nil, ErrShortBuffer
+	}
+	if size > MaxMessageSize {
+		return nil, fmt.Errorf("ed2k: message size %d exceeds maximum %d", size, MaxMessageSize)
+	}
+
+	frame := make([]byte, HeaderLength+int(size))
+	copy(frame, head)
+	if _, err := io.ReadFull(c.r, frame[HeaderLength:]); err != nil {
+		return nil, err
+	}
+
+	frame, err := decompressFrame(frame)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) < HeaderLength+1 {
+		return nil, ErrShortBuffer
+	}
+
+	newMessage, ok := messageRegistry[frame[5]]
+	if !ok {
+		err := fmt.Errorf("ed2k: unknown message type %#x", frame[5])
+		if t := c.tracer(); t != nil {
+			t.OnDecode(frame, nil, err)
+		}
+		return nil, err
+	}
+	msg := newMessage()
+	if ta, ok := msg.(TracerAware); ok {
+		ta.SetTracer(c.tracer())
+	}
+	err = msg.Decode(frame)
+	if err != nil {
+		return nil, err
+	}
+	if idc, ok := msg.(*IDChangeMessage); ok {
+		c.Compression.NegotiateCompression(idc.Bitmap)
+	}
+	return msg, nil
+}
+
+// WriteMessage encodes msg, negotiating compression for CompressionAware
+// types, and writes the framed bytes to the connection.
+func (c *Codec) WriteMessage(msg Message) error {
+	if ca, ok := msg.(CompressionAware); ok {
+		ca.SetCompression(&c.Compression)
+	}
+	if ta, ok := msg.(TracerAware); ok {
+		ta.SetTracer(c.tracer())
+	}
+	data, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	if _, err := c.w.Write(data); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
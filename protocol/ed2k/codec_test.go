@@ -0,0 +1,65 @@
+package ed2k
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	client := NewCodec(clientConn)
+	server := NewCodec(serverConn)
+
+	want := &ServerStatusMessage{UserCount: 42, FileCount: 1337}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.WriteMessage(want)
+	}()
+
+	got, err := server.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+
+	status, ok := got.(*ServerStatusMessage)
+	if !ok {
+		t.Fatalf("got %T, want *ServerStatusMessage", got)
+	}
+	if status.UserCount != want.UserCount || status.FileCount != want.FileCount {
+		t.Fatalf("got %+v, want %+v", status, want)
+	}
+}
+
+func TestCodecNegotiatesCompressionFromIDChange(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	client := NewCodec(clientConn)
+	server := NewCodec(serverConn)
+
+	idChange := &IDChangeMessage{ClientID: 1, Bitmap: 0x1}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.WriteMessage(idChange)
+	}()
+
+	if _, err := server.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+
+	if !server.Compression.Enabled {
+		t.Fatal("expected server codec to negotiate compression from IDChangeMessage.Bitmap")
+	}
+}
@@ -0,0 +1,126 @@
+package ed2k
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+)
+
+// ProtocolEMuleCompressed is the protocol byte used for eMule messages whose
+// body has been zlib-compressed, analogous to the plain ProtocolEDonkey
+// (0xE3) and ProtocolEMule (0xC5) framings but with the payload following
+// the message-type byte run through compress/zlib.
+const ProtocolEMuleCompressed = 0xD4
+
+// CompressionThreshold is the minimum number of raw payload bytes a message
+// body must have before compression is attempted. Messages smaller than
+// this are always sent uncompressed, since the zlib header/footer overhead
+// makes compression counter-productive for small payloads.
+var CompressionThreshold = 128
+
+// CompressionState tracks whether zlib compression was negotiated for a
+// connection. The negotiation happens once, when the server's
+// IDChangeMessage.Bitmap is received: bit 0 set means the server supports
+// compression. Encode implementations consult a CompressionState to
+// decide whether to route a message body through zlib; Decode inflates any
+// ProtocolEMuleCompressed frame whatever the state says.
+type CompressionState struct {
+	// Enabled reports whether compression was negotiated for this connection.
+	Enabled bool
+}
+
+// NegotiateCompression updates the state from the Bitmap carried by an
+// IDChangeMessage. Only the LSB is meaningful; all other bits are reserved.
+func (s *CompressionState) NegotiateCompression(bitmap uint32) {
+	s.Enabled = bitmap&0x1 != 0
+}
+
+// compressPayload zlib-compresses data and returns the compressed bytes
+// along with whether compression actually helped. Compression is skipped
+// (ok == false) when data is shorter than CompressionThreshold or the
+// compressed result is not smaller than the raw payload.
+func compressPayload(data []byte) (compressed []byte, ok bool, err error) {
+	if len(data) < CompressionThreshold {
+		return nil, false, nil
+	}
+	buf := new(bytes.Buffer)
+	w := zlib.NewWriter(buf)
+	if _, err = w.Write(data); err != nil {
+		return nil, false, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, false, err
+	}
+	if buf.Len() >= len(data) {
+		return nil, false, nil
+	}
+	return buf.Bytes(), true, nil
+}
+
+// decompressPayload inflates a zlib-compressed payload produced by
+// compressPayload.
+func decompressPayload(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// compressFrame takes a fully-assembled, plain frame (header + type byte +
+// body, with the size field already patched) and, if state has negotiated
+// compression and the body is worth compressing, rewrites it as a
+// ProtocolEMuleCompressed frame whose payload is the original protocol byte
+// followed by the zlib-deflated [type byte, body]. It returns data unmodified when state is nil, disabled,
+// or compression does not shrink the payload.
+func compressFrame(data []byte, state *CompressionState) ([]byte, error) {
+	if state == nil || !state.Enabled || len(data) < HeaderLength {
+		return data, nil
+	}
+	compressed, ok, err := compressPayload(data[HeaderLength:])
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return data, nil
+	}
+	// The original protocol byte is smuggled in ahead of the zlib stream so
+	// decompressFrame can restore it; the wire protocol byte itself is
+	// overwritten with ProtocolEMuleCompressed below.
+	out := make([]byte, HeaderLength+1+len(compressed))
+	copy(out, data[:HeaderLength])
+	out[HeaderLength] = data[0]
+	copy(out[HeaderLength+1:], compressed)
+	out[0] = ProtocolEMuleCompressed
+	binary.LittleEndian.PutUint32(out[1:5], uint32(1+len(compressed)))
+	return out, nil
+}
+
+// decompressFrame is the inverse of compressFrame. When data is not a
+// ProtocolEMuleCompressed frame it is returned unmodified; otherwise the
+// zlib payload is inflated, the original protocol byte compressFrame
+// smuggled in ahead of it is restored to out[0], and a plain frame (with
+// the size field patched to the inflated length) is returned so the rest
+// of Decode can proceed as if compression had never happened.
+func decompressFrame(data []byte) ([]byte, error) {
+	if len(data) < HeaderLength || data[0] != ProtocolEMuleCompressed {
+		return data, nil
+	}
+	size := binary.LittleEndian.Uint32(data[1:5])
+	if len(data) < HeaderLength+int(size) || size < 1 {
+		return nil, ErrShortBuffer
+	}
+	origProtocol := data[HeaderLength]
+	plain, err := decompressPayload(data[HeaderLength+1 : HeaderLength+int(size)])
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, HeaderLength+len(plain))
+	copy(out, data[:HeaderLength])
+	out[0] = origProtocol
+	copy(out[HeaderLength:], plain)
+	binary.LittleEndian.PutUint32(out[1:5], uint32(len(plain)))
+	return out, nil
+}
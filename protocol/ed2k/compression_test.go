@@ -0,0 +1,116 @@
+package ed2k
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func bigOfferFilesMessage() *OfferFilesMessage {
+	var files []File
+	for i := 0; i < 50; i++ {
+		files = append(files, File{
+			ClientID: uint32(i),
+			Port:     4662,
+		})
+	}
+	return &OfferFilesMessage{Files: files}
+}
+
+func bigServerListMessage() *ServerListMessage {
+	var servers []*net.TCPAddr
+	for i := 0; i < 100; i++ {
+		servers = append(servers, &net.TCPAddr{
+			IP:   net.IPv4(10, 0, byte(i>>8), byte(i)),
+			Port: 4661,
+		})
+	}
+	return &ServerListMessage{Servers: servers}
+}
+
+func TestOfferFilesMessageCompressionRoundTrip(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		m := bigOfferFilesMessage()
+		m.Compression = &CompressionState{Enabled: enabled}
+
+		data, err := m.Encode()
+		if err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		if enabled && data[0] != ProtocolEMuleCompressed {
+			t.Fatalf("expected compressed frame, got protocol byte %#x", data[0])
+		}
+		if !enabled && data[0] == ProtocolEMuleCompressed {
+			t.Fatalf("did not expect a compressed frame")
+		}
+
+		got := &OfferFilesMessage{}
+		if err := got.Decode(data); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if len(got.Files) != len(m.Files) {
+			t.Fatalf("files = %d, want %d", len(got.Files), len(m.Files))
+		}
+	}
+}
+
+func TestServerListMessageCompressionRoundTrip(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		m := bigServerListMessage()
+		m.Compression = &CompressionState{Enabled: enabled}
+
+		data, err := m.Encode()
+		if err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		if enabled && data[0] != ProtocolEMuleCompressed {
+			t.Fatalf("expected compressed frame, got protocol byte %#x", data[0])
+		}
+
+		got := &ServerListMessage{}
+		if err := got.Decode(data); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if len(got.Servers) != len(m.Servers) {
+			t.Fatalf("servers = %d, want %d", len(got.Servers), len(m.Servers))
+		}
+		for i := range got.Servers {
+			if !bytes.Equal(got.Servers[i].IP.To4(), m.Servers[i].IP.To4()) || got.Servers[i].Port != m.Servers[i].Port {
+				t.Fatalf("server %d = %v, want %v", i, got.Servers[i], m.Servers[i])
+			}
+		}
+	}
+}
+
+func TestDecompressFrameRestoresOriginalProtocolByte(t *testing.T) {
+	m := bigOfferFilesMessage()
+	m.Compression = &CompressionState{Enabled: true}
+
+	data, err := m.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if data[0] != ProtocolEMuleCompressed {
+		t.Fatalf("expected compressed frame, got protocol byte %#x", data[0])
+	}
+
+	plain, err := decompressFrame(data)
+	if err != nil {
+		t.Fatalf("decompressFrame: %v", err)
+	}
+	if plain[0] != ProtocolEDonkey {
+		t.Fatalf("decompressFrame left protocol byte %#x, want %#x (ProtocolEDonkey)", plain[0], ProtocolEDonkey)
+	}
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	var s CompressionState
+	s.NegotiateCompression(0x1)
+	if !s.Enabled {
+		t.Fatal("expected compression to be enabled when bitmap LSB is set")
+	}
+	s.NegotiateCompression(0x0)
+	if s.Enabled {
+		t.Fatal("expected compression to be disabled when bitmap LSB is clear")
+	}
+}
@@ -0,0 +1,17 @@
+package ed2k
+
+import "errors"
+
+var (
+	// ErrShortBuffer is returned when a frame is too small to hold the
+	// fields a message's Decode expects.
+	ErrShortBuffer = errors.New("ed2k: short buffer")
+	// ErrWrongMessageType is returned when Decode is called with a frame
+	// whose message-type byte does not match the receiver type.
+	ErrWrongMessageType = errors.New("ed2k: wrong message type")
+	// ErrSearchTreeTooDeep is returned when a SearchRequestMessage's
+	// operator tree nests deeper than maxSearchTreeDepth, guarding against
+	// a hostile peer blowing the stack with a cheaply-encoded chain of
+	// nested NOT nodes.
+	ErrSearchTreeTooDeep = errors.New("ed2k: search tree exceeds maximum depth")
+)
@@ -0,0 +1,65 @@
+package ed2k
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// File describes one file a client is offering or a search matched: its
+// eD2k hash, the offering client's ID and port, and its tag list (name,
+// size, file type, etc. - see Tag).
+type File struct {
+	Hash     [16]byte
+	ClientID uint32
+	Port     uint16
+	Tags     []Tag
+}
+
+// WriteTo serializes f as written in OfferFilesMessage/SearchResultMessage
+// file lists.
+func (f File) WriteTo(buf *bytes.Buffer) (int64, error) {
+	start := buf.Len()
+	buf.Write(f.Hash[:])
+	if err := binary.Write(buf, binary.LittleEndian, f.ClientID); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, f.Port); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(f.Tags))); err != nil {
+		return 0, err
+	}
+	for _, tag := range f.Tags {
+		if _, err := tag.WriteTo(buf); err != nil {
+			return 0, err
+		}
+	}
+	return int64(buf.Len() - start), nil
+}
+
+// ReadFile parses one File, as written by File.WriteTo, from r.
+func ReadFile(r *bytes.Reader) (*File, error) {
+	f := &File{}
+	if _, err := io.ReadFull(r, f.Hash[:]); err != nil {
+		return nil, ErrShortBuffer
+	}
+	if err := binary.Read(r, binary.LittleEndian, &f.ClientID); err != nil {
+		return nil, ErrShortBuffer
+	}
+	if err := binary.Read(r, binary.LittleEndian, &f.Port); err != nil {
+		return nil, ErrShortBuffer
+	}
+	var tagCount uint32
+	if err := binary.Read(r, binary.LittleEndian, &tagCount); err != nil {
+		return nil, ErrShortBuffer
+	}
+	for i := 0; i < int(tagCount); i++ {
+		tag, err := ReadTag(r)
+		if err != nil {
+			return nil, err
+		}
+		f.Tags = append(f.Tags, tag)
+	}
+	return f, nil
+}
@@ -0,0 +1,61 @@
+package ed2k
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Protocol bytes that may appear as the first byte of an eD2k TCP frame.
+const (
+	// ProtocolEDonkey is the standard eDonkey2000 wire protocol.
+	ProtocolEDonkey = 0xE3
+	// ProtocolEMule is the eMule extended wire protocol.
+	ProtocolEMule = 0xC5
+)
+
+// HeaderLength is the size, in bytes, of the frame header every eD2k TCP
+// message starts with: one protocol byte followed by a 4-byte
+// little-endian size of everything that follows (the message-type byte
+// plus the message body).
+const HeaderLength = 5
+
+// Header is the 5-byte frame header shared by every eD2k TCP message.
+type Header struct {
+	// Protocol is the frame's protocol byte (ProtocolEDonkey, ProtocolEMule
+	// or, once compressed, ProtocolEMuleCompressed). The zero value is
+	// treated as ProtocolEDonkey by WriteTo.
+	Protocol byte
+	// Size is the number of bytes following the header: the message-type
+	// byte plus the message body.
+	Size uint32
+}
+
+// WriteTo writes the header to buf. Size is written as whatever value h
+// currently holds; every Encode in this package patches bytes [1:5] of the
+// resulting buffer afterward, once the full frame length is known.
+func (h Header) WriteTo(buf *bytes.Buffer) (int64, error) {
+	protocol := h.Protocol
+	if protocol == 0 {
+		protocol = ProtocolEDonkey
+	}
+	buf.WriteByte(protocol)
+	if err := binary.Write(buf, binary.LittleEndian, h.Size); err != nil {
+		return 0, err
+	}
+	return HeaderLength, nil
+}
+
+// Decode parses the header from the first HeaderLength bytes of data.
+func (h *Header) Decode(data []byte) error {
+	if len(data) < HeaderLength {
+		return ErrShortBuffer
+	}
+	h.Protocol = data[0]
+	h.Size = binary.LittleEndian.Uint32(data[1:5])
+	return nil
+}
+
+func (h Header) String() string {
+	return fmt.Sprintf("protocol: %#x, size: %d", h.Protocol, h.Size)
+}
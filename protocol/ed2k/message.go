@@ -0,0 +1,59 @@
+package ed2k
+
+import (
+	"fmt"
+	"net"
+)
+
+// message is embedded by every concrete Message type in this package. It
+// carries the frame Header all of them share, plus an optional per-instance
+// Tracer a Codec installs via SetTracer before Encode/Decode so a message
+// used directly, without a Codec, still traces through DefaultTracer.
+type message struct {
+	Header Header
+	tracer Tracer
+}
+
+// SetTracer implements TracerAware.
+func (m *message) SetTracer(t Tracer) {
+	m.tracer = t
+}
+
+// activeTracer returns the Tracer a Codec installed on this message,
+// falling back to DefaultTracer.
+func (m *message) activeTracer() Tracer {
+	if m.tracer != nil {
+		return m.tracer
+	}
+	return DefaultTracer
+}
+
+// eD2k TCP message-type bytes, carried immediately after the frame Header.
+const (
+	MessageLoginRequest  = 0x01
+	MessageGetServerList = 0x14
+	MessageOfferFiles    = 0x15
+	MessageSearchRequest = 0x16
+	MessageSearchResult  = 0x20
+	MessageServerList    = 0x32
+	MessageServerStatus  = 0x34
+	MessageServerMessage = 0x38
+	MessageIDChange      = 0x40
+	MessageServerIdent   = 0x41
+)
+
+// LowIDThreshold is the smallest value ClientID treats as a high (routable) ID.
+const LowIDThreshold = 0x00800000
+
+// ClientID identifies a client to the server. IDs below LowIDThreshold are
+// "low IDs" assigned to clients the server could not connect back to (e.g.
+// behind NAT); IDs at or above it are the client's IPv4 address packed
+// little-endian, the classic eD2k "high ID".
+type ClientID uint32
+
+func (c ClientID) String() string {
+	if uint32(c) < LowIDThreshold {
+		return fmt.Sprintf("low-id:%d", uint32(c))
+	}
+	return net.IPv4(byte(c), byte(c>>8), byte(c>>16), byte(c>>24)).String()
+}
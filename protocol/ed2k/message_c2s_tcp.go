@@ -23,6 +23,13 @@ func (m *LoginMessage) Encode() (data []byte, err error) {
 	if m == nil {
 		return
 	}
+	defer func() {
+		if err == nil {
+			if t := m.activeTracer(); t != nil {
+				t.OnEncode(m, data)
+			}
+		}
+	}()
 	buf := new(bytes.Buffer)
 	if _, err = m.Header.WriteTo(buf); err != nil {
 		return
@@ -57,6 +64,12 @@ func (m *LoginMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *LoginMessage) Decode(data []byte) (err error) {
+	wire := data
+	defer func() {
+		if t := m.activeTracer(); t != nil {
+			t.OnDecode(wire, m, err)
+		}
+	}()
 	header := Header{}
 	err = header.Decode(data)
 	if err != nil {
@@ -126,6 +139,13 @@ func (m *ServerMessage) Encode() (data []byte, err error) {
 	if m == nil {
 		return
 	}
+	defer func() {
+		if err == nil {
+			if t := m.activeTracer(); t != nil {
+				t.OnEncode(m, data)
+			}
+		}
+	}()
 	buf := new(bytes.Buffer)
 
 	if _, err = m.Header.WriteTo(buf); err != nil {
@@ -150,6 +170,12 @@ func (m *ServerMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *ServerMessage) Decode(data []byte) (err error) {
+	wire := data
+	defer func() {
+		if t := m.activeTracer(); t != nil {
+			t.OnDecode(wire, m, err)
+		}
+	}()
 	header := Header{}
 	err = header.Decode(data)
 	if err != nil {
@@ -202,6 +228,13 @@ func (m *IDChangeMessage) Encode() (data []byte, err error) {
 	if m == nil {
 		return
 	}
+	defer func() {
+		if err == nil {
+			if t := m.activeTracer(); t != nil {
+				t.OnEncode(m, data)
+			}
+		}
+	}()
 	buf := new(bytes.Buffer)
 	if _, err = m.Header.WriteTo(buf); err != nil {
 		return
@@ -224,6 +257,12 @@ func (m *IDChangeMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *IDChangeMessage) Decode(data []byte) (err error) {
+	wire := data
+	defer func() {
+		if t := m.activeTracer(); t != nil {
+			t.OnDecode(wire, m, err)
+		}
+	}()
 	header := Header{}
 	err = header.Decode(data)
 	if err != nil {
@@ -268,6 +307,11 @@ type OfferFilesMessage struct {
 	// An optional list of files, in any case no more than 200.
 	// The Server can also set a lower limit to this number.
 	Files []File
+	// Compression, when non-nil, controls whether Encode routes the
+	// message body through zlib; Decode inflates a ProtocolEMuleCompressed
+	// frame regardless of it. It is normally shared with the connection
+	// that negotiated it via IDChangeMessage.Bitmap.
+	Compression *CompressionState
 }
 
 // Encode encodes the message to binary data.
@@ -275,6 +319,13 @@ func (m *OfferFilesMessage) Encode() (data []byte, err error) {
 	if m == nil {
 		return
 	}
+	defer func() {
+		if err == nil {
+			if t := m.activeTracer(); t != nil {
+				t.OnEncode(m, data)
+			}
+		}
+	}()
 	buf := new(bytes.Buffer)
 	if _, err = m.Header.WriteTo(buf); err != nil {
 		return
@@ -294,11 +345,21 @@ func (m *OfferFilesMessage) Encode() (data []byte, err error) {
 	size := len(data) - HeaderLength
 	binary.LittleEndian.PutUint32(data[1:5], uint32(size)) // message size
 
+	data, err = compressFrame(data, m.Compression)
 	return
 }
 
 // Decode decodes the message from binary data.
 func (m *OfferFilesMessage) Decode(data []byte) (err error) {
+	wire := data
+	defer func() {
+		if t := m.activeTracer(); t != nil {
+			t.OnDecode(wire, m, err)
+		}
+	}()
+	if data, err = decompressFrame(data); err != nil {
+		return
+	}
 	header := Header{}
 	err = header.Decode(data)
 	if err != nil {
@@ -327,6 +388,11 @@ func (m *OfferFilesMessage) Decode(data []byte) (err error) {
 	return
 }
 
+// SetCompression implements CompressionAware.
+func (m *OfferFilesMessage) SetCompression(s *CompressionState) {
+	m.Compression = s
+}
+
 // Type is the message type
 func (m OfferFilesMessage) Type() uint8 {
 	return MessageOfferFiles
@@ -358,6 +424,13 @@ func (m *GetServerListMessage) Encode() (data []byte, err error) {
 	if m == nil {
 		return
 	}
+	defer func() {
+		if err == nil {
+			if t := m.activeTracer(); t != nil {
+				t.OnEncode(m, data)
+			}
+		}
+	}()
 	buf := new(bytes.Buffer)
 	if _, err = m.Header.WriteTo(buf); err != nil {
 		return
@@ -373,6 +446,12 @@ func (m *GetServerListMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *GetServerListMessage) Decode(data []byte) (err error) {
+	wire := data
+	defer func() {
+		if t := m.activeTracer(); t != nil {
+			t.OnDecode(wire, m, err)
+		}
+	}()
 	header := Header{}
 	err = header.Decode(data)
 	if err != nil {
@@ -409,6 +488,11 @@ type ServerListMessage struct {
 	message
 	// Server descriptor entries, each entry size is 6 bytes and contains 4 bytes IP address and then 2 byte TCP port.
 	Servers []*net.TCPAddr
+	// Compression, when non-nil, controls whether Encode routes the
+	// message body through zlib; Decode inflates a ProtocolEMuleCompressed
+	// frame regardless of it. It is normally shared with the connection
+	// that negotiated it via IDChangeMessage.Bitmap.
+	Compression *CompressionState
 }
 
 // Encode encodes the message to binary data.
@@ -416,6 +500,13 @@ func (m *ServerListMessage) Encode() (data []byte, err error) {
 	if m == nil {
 		return
 	}
+	defer func() {
+		if err == nil {
+			if t := m.activeTracer(); t != nil {
+				t.OnEncode(m, data)
+			}
+		}
+	}()
 	buf := new(bytes.Buffer)
 	if _, err = m.Header.WriteTo(buf); err != nil {
 		return
@@ -438,11 +529,21 @@ func (m *ServerListMessage) Encode() (data []byte, err error) {
 	size := len(data) - HeaderLength
 	binary.LittleEndian.PutUint32(data[1:5], uint32(size)) // message size
 
+	data, err = compressFrame(data, m.Compression)
 	return
 }
 
 // Decode decodes the message from binary data.
 func (m *ServerListMessage) Decode(data []byte) (err error) {
+	wire := data
+	defer func() {
+		if t := m.activeTracer(); t != nil {
+			t.OnDecode(wire, m, err)
+		}
+	}()
+	if data, err = decompressFrame(data); err != nil {
+		return
+	}
 	header := Header{}
 	err = header.Decode(data)
 	if err != nil {
@@ -476,6 +577,11 @@ func (m *ServerListMessage) Decode(data []byte) (err error) {
 	return
 }
 
+// SetCompression implements CompressionAware.
+func (m *ServerListMessage) SetCompression(s *CompressionState) {
+	m.Compression = s
+}
+
 // Type is the message type
 func (m ServerListMessage) Type() uint8 {
 	return MessageServerList
@@ -511,6 +617,13 @@ func (m *ServerStatusMessage) Encode() (data []byte, err error) {
 	if m == nil {
 		return
 	}
+	defer func() {
+		if err == nil {
+			if t := m.activeTracer(); t != nil {
+				t.OnEncode(m, data)
+			}
+		}
+	}()
 	buf := new(bytes.Buffer)
 	if _, err = m.Header.WriteTo(buf); err != nil {
 		return
@@ -533,6 +646,12 @@ func (m *ServerStatusMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *ServerStatusMessage) Decode(data []byte) (err error) {
+	wire := data
+	defer func() {
+		if t := m.activeTracer(); t != nil {
+			t.OnDecode(wire, m, err)
+		}
+	}()
 	header := Header{}
 	err = header.Decode(data)
 	if err != nil {
@@ -590,6 +709,13 @@ func (m *ServerIdentMessage) Encode() (data []byte, err error) {
 	if m == nil {
 		return
 	}
+	defer func() {
+		if err == nil {
+			if t := m.activeTracer(); t != nil {
+				t.OnEncode(m, data)
+			}
+		}
+	}()
 	buf := new(bytes.Buffer)
 	if _, err = m.Header.WriteTo(buf); err != nil {
 		return
@@ -622,6 +748,12 @@ func (m *ServerIdentMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *ServerIdentMessage) Decode(data []byte) (err error) {
+	wire := data
+	defer func() {
+		if t := m.activeTracer(); t != nil {
+			t.OnDecode(wire, m, err)
+		}
+	}()
 	header := Header{}
 	err = header.Decode(data)
 	if err != nil {
@@ -673,7 +805,3 @@ func (m ServerIdentMessage) String() string {
 	}
 	return b.String()
 }
-
-type SearchRequestMessage struct {
-	message
-}
@@ -0,0 +1,490 @@
+package ed2k
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Wire tags for the nodes of a search expression tree, as used by
+// SearchRequestMessage.
+const (
+	searchWireOperator = 0x00
+	searchWireString   = 0x01
+	searchWireNumeric  = 0x03
+)
+
+// SearchOp identifies the boolean operator carried by an operator SearchNode.
+type SearchOp uint8
+
+const (
+	// SearchAnd requires both operands to match.
+	SearchAnd SearchOp = iota
+	// SearchOr requires either operand to match.
+	SearchOr
+	// SearchNot negates its single operand (Right is unused).
+	SearchNot
+)
+
+// SearchCompare identifies the comparison applied by a numeric SearchNode leaf.
+type SearchCompare uint8
+
+const (
+	SearchEqual SearchCompare = iota
+	SearchGreater
+	SearchGreaterEqual
+	SearchLess
+	SearchLessEqual
+	SearchNotEqual
+)
+
+// SearchField identifies which criterion a leaf SearchNode tests.
+type SearchField uint8
+
+const (
+	// SearchFieldName matches a case-insensitive substring of the file name.
+	SearchFieldName SearchField = iota
+	// SearchFieldType matches the eD2k file-type string (e.g. "Video").
+	SearchFieldType
+	// SearchFieldExtension matches the file extension (e.g. "mkv").
+	SearchFieldExtension
+	// SearchFieldMinSize matches files no smaller than a given size, in bytes.
+	SearchFieldMinSize
+	// SearchFieldMaxSize matches files no larger than a given size, in bytes.
+	SearchFieldMaxSize
+	// SearchFieldAvailability matches the number of known sources for a file.
+	SearchFieldAvailability
+	// SearchFieldCompleteSources matches the number of complete sources for a file.
+	SearchFieldCompleteSources
+)
+
+// tagName returns the eD2k tag name conventionally associated with a numeric field.
+func (f SearchField) tagName() string {
+	switch f {
+	case SearchFieldMinSize, SearchFieldMaxSize:
+		return "size"
+	case SearchFieldAvailability:
+		return "availability"
+	case SearchFieldCompleteSources:
+		return "complete sources"
+	default:
+		return ""
+	}
+}
+
+// SearchNode is one node of the boolean expression tree carried by
+// SearchRequestMessage. A node is either an operator (Op set, Left and,
+// for AND/OR, Right populated) or a leaf (Field set, and either StringValue
+// or NumericValue/Compare populated depending on the field).
+type SearchNode struct {
+	// Op is meaningful only for operator nodes; the zero value (SearchAnd)
+	// has no meaning on a leaf node.
+	Op          SearchOp
+	Left, Right *SearchNode
+
+	// Field identifies the criterion for a leaf node.
+	Field SearchField
+
+	// StringValue holds the match text for SearchFieldName, SearchFieldType
+	// and SearchFieldExtension leaves.
+	StringValue string
+
+	// Compare and NumericValue hold the comparison for SearchFieldMinSize,
+	// SearchFieldMaxSize, SearchFieldAvailability and
+	// SearchFieldCompleteSources leaves.
+	Compare      SearchCompare
+	NumericValue uint32
+
+	isOperator bool
+}
+
+// isStringField reports whether f is carried as a string leaf on the wire.
+func (f SearchField) isStringField() bool {
+	switch f {
+	case SearchFieldName, SearchFieldType, SearchFieldExtension:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewOperatorNode builds an AND/OR node from two operands, or a NOT node
+// from a single operand (right is ignored for SearchNot).
+func NewOperatorNode(op SearchOp, left, right *SearchNode) *SearchNode {
+	return &SearchNode{Op: op, Left: left, Right: right, isOperator: true}
+}
+
+// NewStringLeaf builds a string-valued leaf node (name, type or extension).
+func NewStringLeaf(field SearchField, value string) *SearchNode {
+	return &SearchNode{Field: field, StringValue: value}
+}
+
+// NewNumericLeaf builds a numeric leaf node (size, availability or complete-sources).
+func NewNumericLeaf(field SearchField, compare SearchCompare, value uint32) *SearchNode {
+	return &SearchNode{Field: field, Compare: compare, NumericValue: value}
+}
+
+// WriteTo serializes the subtree rooted at n in prefix order.
+func (n *SearchNode) WriteTo(buf *bytes.Buffer) (int64, error) {
+	start := buf.Len()
+	if n == nil {
+		return 0, nil
+	}
+	if n.isOperator {
+		buf.WriteByte(searchWireOperator)
+		buf.WriteByte(byte(n.Op))
+		if _, err := n.Left.WriteTo(buf); err != nil {
+			return 0, err
+		}
+		if n.Op != SearchNot {
+			if _, err := n.Right.WriteTo(buf); err != nil {
+				return 0, err
+			}
+		}
+		return int64(buf.Len() - start), nil
+	}
+	if n.Field.isStringField() {
+		buf.WriteByte(searchWireString)
+		buf.WriteByte(byte(n.Field))
+		if err := binary.Write(buf, binary.LittleEndian, uint16(len(n.StringValue))); err != nil {
+			return 0, err
+		}
+		buf.WriteString(n.StringValue)
+		return int64(buf.Len() - start), nil
+	}
+	buf.WriteByte(searchWireNumeric)
+	buf.WriteByte(byte(n.Field))
+	buf.WriteByte(byte(n.Compare))
+	if err := binary.Write(buf, binary.LittleEndian, n.NumericValue); err != nil {
+		return 0, err
+	}
+	tag := n.Field.tagName()
+	if err := binary.Write(buf, binary.LittleEndian, uint16(len(tag))); err != nil {
+		return 0, err
+	}
+	buf.WriteString(tag)
+	return int64(buf.Len() - start), nil
+}
+
+// maxSearchTreeDepth bounds the recursion readSearchNode performs while
+// parsing nested operator nodes. A NOT node costs only 2 wire bytes, so
+// without a limit a single message within MaxMessageSize could
+// encode tens of millions of nested nodes and overflow the goroutine stack.
+const maxSearchTreeDepth = 64
+
+// readSearchNode parses one SearchNode, and the subtree below it, from r.
+func readSearchNode(r *bytes.Reader) (*SearchNode, error) {
+	return readSearchNodeDepth(r, 0)
+}
+
+func readSearchNodeDepth(r *bytes.Reader, depth int) (*SearchNode, error) {
+	if depth > maxSearchTreeDepth {
+		return nil, ErrSearchTreeTooDeep
+	}
+	wire, err := r.ReadByte()
+	if err != nil {
+		return nil, ErrShortBuffer
+	}
+	switch wire {
+	case searchWireOperator:
+		opByte, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrShortBuffer
+		}
+		op := SearchOp(opByte)
+		left, err := readSearchNodeDepth(r, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		var right *SearchNode
+		if op != SearchNot {
+			right, err = readSearchNodeDepth(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &SearchNode{Op: op, Left: left, Right: right, isOperator: true}, nil
+	case searchWireString:
+		fieldByte, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrShortBuffer
+		}
+		var length uint16
+		if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+			return nil, ErrShortBuffer
+		}
+		value := make([]byte, length)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, ErrShortBuffer
+		}
+		return NewStringLeaf(SearchField(fieldByte), string(value)), nil
+	case searchWireNumeric:
+		fieldByte, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrShortBuffer
+		}
+		compareByte, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrShortBuffer
+		}
+		var value uint32
+		if err := binary.Read(r, binary.LittleEndian, &value); err != nil {
+			return nil, ErrShortBuffer
+		}
+		var tagLen uint16
+		if err := binary.Read(r, binary.LittleEndian, &tagLen); err != nil {
+			return nil, ErrShortBuffer
+		}
+		tag := make([]byte, tagLen)
+		if _, err := io.ReadFull(r, tag); err != nil {
+			return nil, ErrShortBuffer
+		}
+		return NewNumericLeaf(SearchField(fieldByte), SearchCompare(compareByte), value), nil
+	default:
+		return nil, ErrWrongMessageType
+	}
+}
+
+// SearchQuery builds a SearchNode tree incrementally, combining each added
+// criterion with SearchAnd. Use NewSearchQuery to start one.
+type SearchQuery struct {
+	root *SearchNode
+}
+
+// NewSearchQuery returns an empty search query builder.
+func NewSearchQuery() *SearchQuery {
+	return &SearchQuery{}
+}
+
+func (q *SearchQuery) and(leaf *SearchNode) *SearchQuery {
+	if q.root == nil {
+		q.root = leaf
+	} else {
+		q.root = NewOperatorNode(SearchAnd, q.root, leaf)
+	}
+	return q
+}
+
+// Name requires name to appear as a substring of the file name.
+func (q *SearchQuery) Name(name string) *SearchQuery {
+	return q.and(NewStringLeaf(SearchFieldName, name))
+}
+
+// Type requires the file type tag to equal fileType (e.g. "Video", "Audio").
+func (q *SearchQuery) Type(fileType string) *SearchQuery {
+	return q.and(NewStringLeaf(SearchFieldType, fileType))
+}
+
+// Extension requires the file extension to equal ext (e.g. "mkv").
+func (q *SearchQuery) Extension(ext string) *SearchQuery {
+	return q.and(NewStringLeaf(SearchFieldExtension, ext))
+}
+
+// MinSize requires the file size to be at least size bytes.
+func (q *SearchQuery) MinSize(size uint32) *SearchQuery {
+	return q.and(NewNumericLeaf(SearchFieldMinSize, SearchGreaterEqual, size))
+}
+
+// MaxSize requires the file size to be at most size bytes.
+func (q *SearchQuery) MaxSize(size uint32) *SearchQuery {
+	return q.and(NewNumericLeaf(SearchFieldMaxSize, SearchLessEqual, size))
+}
+
+// Availability requires at least count known sources for the file.
+func (q *SearchQuery) Availability(count uint32) *SearchQuery {
+	return q.and(NewNumericLeaf(SearchFieldAvailability, SearchGreaterEqual, count))
+}
+
+// CompleteSources requires at least count complete sources for the file.
+func (q *SearchQuery) CompleteSources(count uint32) *SearchQuery {
+	return q.and(NewNumericLeaf(SearchFieldCompleteSources, SearchGreaterEqual, count))
+}
+
+// Build returns the accumulated search tree, or nil if no criteria were added.
+func (q *SearchQuery) Build() *SearchNode {
+	return q.root
+}
+
+// SearchRequestMessage is sent by the client to query the server's file
+// index using a boolean expression tree of name/size/type/availability
+// criteria. Build the Tree with NewSearchQuery or by hand with
+// NewOperatorNode/NewStringLeaf/NewNumericLeaf.
+type SearchRequestMessage struct {
+	message
+	Tree *SearchNode
+}
+
+// Encode encodes the message to binary data.
+func (m *SearchRequestMessage) Encode() (data []byte, err error) {
+	if m == nil {
+		return
+	}
+	defer func() {
+		if err == nil {
+			if t := m.activeTracer(); t != nil {
+				t.OnEncode(m, data)
+			}
+		}
+	}()
+	buf := new(bytes.Buffer)
+	if _, err = m.Header.WriteTo(buf); err != nil {
+		return
+	}
+	buf.WriteByte(MessageSearchRequest)
+
+	if _, err = m.Tree.WriteTo(buf); err != nil {
+		return
+	}
+
+	data = buf.Bytes()
+	size := len(data) - HeaderLength
+	binary.LittleEndian.PutUint32(data[1:5], uint32(size)) // message size
+
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *SearchRequestMessage) Decode(data []byte) (err error) {
+	wire := data
+	defer func() {
+		if t := m.activeTracer(); t != nil {
+			t.OnDecode(wire, m, err)
+		}
+	}()
+	header := Header{}
+	err = header.Decode(data)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength
+	if header.Size < 1 ||
+		len(data) < pos+int(header.Size) ||
+		len(data) < pos+1 {
+		return ErrShortBuffer
+	}
+	if data[5] != MessageSearchRequest {
+		return ErrWrongMessageType
+	}
+	m.Header = header
+	pos++
+
+	r := bytes.NewReader(data[pos : pos+int(header.Size)-1])
+	tree, err := readSearchNode(r)
+	if err != nil {
+		return err
+	}
+	m.Tree = tree
+	return nil
+}
+
+// Type is the message type
+func (m SearchRequestMessage) Type() uint8 {
+	return MessageSearchRequest
+}
+
+func (m SearchRequestMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[search-request]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "tree: %+v", m.Tree)
+	return b.String()
+}
+
+// SearchResultMessage is sent by the server in response to a
+// SearchRequestMessage, mirroring OfferFilesMessage's layout of file
+// descriptors (hash, client ID, port and tag list).
+type SearchResultMessage struct {
+	message
+	Files []File
+}
+
+// Encode encodes the message to binary data.
+func (m *SearchResultMessage) Encode() (data []byte, err error) {
+	if m == nil {
+		return
+	}
+	defer func() {
+		if err == nil {
+			if t := m.activeTracer(); t != nil {
+				t.OnEncode(m, data)
+			}
+		}
+	}()
+	buf := new(bytes.Buffer)
+	if _, err = m.Header.WriteTo(buf); err != nil {
+		return
+	}
+	buf.WriteByte(MessageSearchResult)
+
+	if err = binary.Write(buf, binary.LittleEndian, uint32(len(m.Files))); err != nil {
+		return
+	}
+	for _, file := range m.Files {
+		if _, err = file.WriteTo(buf); err != nil {
+			return
+		}
+	}
+
+	data = buf.Bytes()
+	size := len(data) - HeaderLength
+	binary.LittleEndian.PutUint32(data[1:5], uint32(size)) // message size
+
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *SearchResultMessage) Decode(data []byte) (err error) {
+	wire := data
+	defer func() {
+		if t := m.activeTracer(); t != nil {
+			t.OnDecode(wire, m, err)
+		}
+	}()
+	header := Header{}
+	err = header.Decode(data)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength
+	if len(data) < pos+int(header.Size) ||
+		len(data) < pos+5 {
+		return ErrShortBuffer
+	}
+	if data[5] != MessageSearchResult {
+		return ErrWrongMessageType
+	}
+	m.Header = header
+	pos++
+	fileCount := binary.LittleEndian.Uint32(data[pos : pos+4])
+	pos += 4
+	r := bytes.NewReader(data[pos:])
+	for i := 0; i < int(fileCount); i++ {
+		file, err := ReadFile(r)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, *file)
+	}
+	return
+}
+
+// Type is the message type
+func (m SearchResultMessage) Type() uint8 {
+	return MessageSearchResult
+}
+
+func (m SearchResultMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[search-result]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\nfiles:\n")
+	for i, file := range m.Files {
+		fmt.Fprintf(&b, "file%d - %X %s:%d\n", i, file.Hash, ClientID(file.ClientID).String(), file.Port)
+		for j, tag := range file.Tags {
+			fmt.Fprintf(&b, "tag%d - %v: %v\n", j, tag.Name(), tag.Value())
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,125 @@
+package ed2k
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestSearchQueryBuilder(t *testing.T) {
+	tree := NewSearchQuery().Name("foo").MinSize(1 << 20).Type("Video").Build()
+
+	data, err := (&SearchRequestMessage{Tree: tree}).Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	got := &SearchRequestMessage{}
+	if err := got.Decode(data); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	// The builder folds criteria left-associatively with AND, so the
+	// outermost node is the last criterion added.
+	root := got.Tree
+	if !root.isOperator || root.Op != SearchAnd {
+		t.Fatalf("root = %+v, want AND node", root)
+	}
+	typeLeaf := root.Right
+	if typeLeaf.Field != SearchFieldType || typeLeaf.StringValue != "Video" {
+		t.Fatalf("type leaf = %+v", typeLeaf)
+	}
+
+	mid := root.Left
+	if !mid.isOperator || mid.Op != SearchAnd {
+		t.Fatalf("mid = %+v, want AND node", mid)
+	}
+	sizeLeaf := mid.Right
+	if sizeLeaf.Field != SearchFieldMinSize || sizeLeaf.Compare != SearchGreaterEqual || sizeLeaf.NumericValue != 1<<20 {
+		t.Fatalf("size leaf = %+v", sizeLeaf)
+	}
+
+	nameLeaf := mid.Left
+	if nameLeaf.Field != SearchFieldName || nameLeaf.StringValue != "foo" {
+		t.Fatalf("name leaf = %+v", nameLeaf)
+	}
+}
+
+func TestSearchRequestMessageRoundTripNot(t *testing.T) {
+	tree := NewOperatorNode(SearchNot, NewStringLeaf(SearchFieldExtension, "exe"), nil)
+
+	data, err := (&SearchRequestMessage{Tree: tree}).Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	got := &SearchRequestMessage{}
+	if err := got.Decode(data); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !got.Tree.isOperator || got.Tree.Op != SearchNot {
+		t.Fatalf("tree = %+v, want NOT node", got.Tree)
+	}
+	if got.Tree.Left.Field != SearchFieldExtension || got.Tree.Left.StringValue != "exe" {
+		t.Fatalf("NOT operand = %+v", got.Tree.Left)
+	}
+}
+
+func TestSearchRequestMessageDecodeTruncatedStringLeaf(t *testing.T) {
+	tree := NewStringLeaf(SearchFieldName, strings.Repeat("x", 50))
+	data, err := (&SearchRequestMessage{Tree: tree}).Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	truncated := data[:len(data)-10]
+	binary.LittleEndian.PutUint32(truncated[1:5], uint32(len(truncated)-HeaderLength))
+
+	got := &SearchRequestMessage{}
+	if err := got.Decode(truncated); err == nil {
+		t.Fatalf("decode of truncated frame succeeded with corrupted data, want ErrShortBuffer")
+	}
+}
+
+func TestReadSearchNodeRejectsExcessiveDepth(t *testing.T) {
+	tree := NewOperatorNode(SearchNot, NewStringLeaf(SearchFieldExtension, "exe"), nil)
+	for i := 0; i < maxSearchTreeDepth+1; i++ {
+		tree = NewOperatorNode(SearchNot, tree, nil)
+	}
+
+	data, err := (&SearchRequestMessage{Tree: tree}).Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	got := &SearchRequestMessage{}
+	if err := got.Decode(data); err != ErrSearchTreeTooDeep {
+		t.Fatalf("decode = %v, want ErrSearchTreeTooDeep", err)
+	}
+}
+
+func TestSearchResultMessageRoundTrip(t *testing.T) {
+	m := &SearchResultMessage{
+		Files: []File{
+			{ClientID: 1, Port: 4662},
+			{ClientID: 2, Port: 4663},
+		},
+	}
+	data, err := m.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	got := &SearchResultMessage{}
+	if err := got.Decode(data); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(got.Files) != len(m.Files) {
+		t.Fatalf("files = %d, want %d", len(got.Files), len(m.Files))
+	}
+	for i := range got.Files {
+		if got.Files[i].ClientID != m.Files[i].ClientID || got.Files[i].Port != m.Files[i].Port {
+			t.Fatalf("file %d = %+v, want %+v", i, got.Files[i], m.Files[i])
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package ed2k
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// Tag is a single name/value attribute attached to a LoginMessage, File or
+// ServerIdentMessage (e.g. the client's nickname or version). Concrete tag
+// types are StringTag and IntTag; use ReadTag to parse either from the wire.
+type Tag interface {
+	Name() string
+	Value() interface{}
+	WriteTo(buf *bytes.Buffer) (int64, error)
+}
+
+// Wire type bytes for the tags this package knows how to read and write.
+const (
+	tagWireString = 0x01
+	tagWireInt    = 0x02
+)
+
+// StringTag is a Tag whose value is a UTF-8 string, e.g. the client name tag.
+type StringTag struct {
+	TagName  string
+	TagValue string
+}
+
+// Name implements Tag.
+func (t StringTag) Name() string { return t.TagName }
+
+// Value implements Tag.
+func (t StringTag) Value() interface{} { return t.TagValue }
+
+// WriteTo implements Tag.
+func (t StringTag) WriteTo(buf *bytes.Buffer) (int64, error) {
+	start := buf.Len()
+	buf.WriteByte(tagWireString)
+	buf.WriteByte(byte(len(t.TagName)))
+	buf.WriteString(t.TagName)
+	if err := binary.Write(buf, binary.LittleEndian, uint16(len(t.TagValue))); err != nil {
+		return 0, err
+	}
+	buf.WriteString(t.TagValue)
+	return int64(buf.Len() - start), nil
+}
+
+// IntTag is a Tag whose value is a 32-bit integer, e.g. the client version tag.
+type IntTag struct {
+	TagName  string
+	TagValue uint32
+}
+
+// Name implements Tag.
+func (t IntTag) Name() string { return t.TagName }
+
+// Value implements Tag.
+func (t IntTag) Value() interface{} { return t.TagValue }
+
+// WriteTo implements Tag.
+func (t IntTag) WriteTo(buf *bytes.Buffer) (int64, error) {
+	start := buf.Len()
+	buf.WriteByte(tagWireInt)
+	buf.WriteByte(byte(len(t.TagName)))
+	buf.WriteString(t.TagName)
+	if err := binary.Write(buf, binary.LittleEndian, t.TagValue); err != nil {
+		return 0, err
+	}
+	return int64(buf.Len() - start), nil
+}
+
+// ReadTag parses one Tag (StringTag or IntTag) from r.
+func ReadTag(r *bytes.Reader) (Tag, error) {
+	wire, err := r.ReadByte()
+	if err != nil {
+		return nil, ErrShortBuffer
+	}
+	nameLen, err := r.ReadByte()
+	if err != nil {
+		return nil, ErrShortBuffer
+	}
+	name := make([]byte, nameLen)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return nil, ErrShortBuffer
+	}
+	switch wire {
+	case tagWireString:
+		var valueLen uint16
+		if err := binary.Read(r, binary.LittleEndian, &valueLen); err != nil {
+			return nil, ErrShortBuffer
+		}
+		value := make([]byte, valueLen)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, ErrShortBuffer
+		}
+		return StringTag{TagName: string(name), TagValue: string(value)}, nil
+	case tagWireInt:
+		var value uint32
+		if err := binary.Read(r, binary.LittleEndian, &value); err != nil {
+			return nil, ErrShortBuffer
+		}
+		return IntTag{TagName: string(name), TagValue: value}, nil
+	default:
+		return nil, ErrWrongMessageType
+	}
+}
@@ -0,0 +1,193 @@
+package ed2k
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Tracer observes every message a Codec encodes or decodes. OnEncode is
+// called with the message and the wire bytes Encode produced; OnDecode is
+// called with the frame Decode was given, the message being decoded into
+// and any decode error. On a decode error the message may be partially
+// populated; it is nil only when Codec.ReadMessage finds no registered
+// type for the frame. A frame read through a Codec has already been
+// decompressed, so OnDecode sees the inflated frame rather than the
+// compressed bytes on the wire. Implementations must not retain the wire
+// slice past the call, as callers may reuse its backing array.
+type Tracer interface {
+	OnEncode(msg Message, wire []byte)
+	OnDecode(wire []byte, msg Message, err error)
+}
+
+// TracerAware is implemented by every Message type in this package. Codec
+// uses it to install its own Tracer on a message before calling Encode or
+// Decode, so the message's own trace calls reach the Codec's tracer rather
+// than just DefaultTracer.
+type TracerAware interface {
+	SetTracer(Tracer)
+}
+
+// DefaultTracer, when non-nil, is used by any message that was not given
+// its own Tracer via SetTracer - in particular one encoded or decoded
+// directly, without going through a Codec. It is nil (tracing disabled) by
+// default.
+var DefaultTracer Tracer
+
+func (c *Codec) tracer() Tracer {
+	if c.Tracer != nil {
+		return c.Tracer
+	}
+	return DefaultTracer
+}
+
+// HexTracer writes a hex.Dump of every traced frame to W, preceded by the
+// message's String() header, for eyeballing live sessions in a terminal.
+type HexTracer struct {
+	W io.Writer
+}
+
+// OnEncode implements Tracer.
+func (t *HexTracer) OnEncode(msg Message, wire []byte) {
+	fmt.Fprintf(t.W, "--> %s\n%s", msg.String(), hex.Dump(wire))
+}
+
+// OnDecode implements Tracer.
+func (t *HexTracer) OnDecode(wire []byte, msg Message, err error) {
+	if err != nil {
+		fmt.Fprintf(t.W, "<-- decode error: %v\n%s", err, hex.Dump(wire))
+		return
+	}
+	fmt.Fprintf(t.W, "<-- %s\n%s", msg.String(), hex.Dump(wire))
+}
+
+// PcapTracer writes traced frames to a pcap-ng file as synthetic Ethernet/
+// IPv4/TCP packets, so a live session can be opened directly in Wireshark.
+// Each traced frame is wrapped with LocalAddr/RemoteAddr as the endpoints,
+// alternating direction between OnEncode (LocalAddr -> RemoteAddr) and
+// OnDecode (RemoteAddr -> LocalAddr).
+type PcapTracer struct {
+	W          io.Writer
+	LocalAddr  *net.TCPAddr
+	RemoteAddr *net.TCPAddr
+
+	wroteHeader bool
+	seq, ack    uint32
+}
+
+// OnEncode implements Tracer.
+func (t *PcapTracer) OnEncode(msg Message, wire []byte) {
+	t.writePacket(t.LocalAddr, t.RemoteAddr, wire, &t.seq, t.ack)
+}
+
+// OnDecode implements Tracer.
+func (t *PcapTracer) OnDecode(wire []byte, msg Message, err error) {
+	if err != nil {
+		return
+	}
+	t.writePacket(t.RemoteAddr, t.LocalAddr, wire, &t.ack, t.seq)
+}
+
+func (t *PcapTracer) writePacket(src, dst *net.TCPAddr, payload []byte, selfSeq *uint32, peerAck uint32) {
+	if !t.wroteHeader {
+		t.writeSectionHeader()
+		t.writeInterfaceDescription()
+		t.wroteHeader = true
+	}
+
+	eth := buildEthernetIPv4TCP(src, dst, payload, *selfSeq, peerAck)
+	*selfSeq += uint32(len(payload))
+	t.writeEnhancedPacketBlock(eth)
+}
+
+// writeSectionHeader writes a minimal pcap-ng Section Header Block.
+func (t *PcapTracer) writeSectionHeader() {
+	block := make([]byte, 28)
+	binary.LittleEndian.PutUint32(block[0:4], 0x0A0D0D0A)           // block type
+	binary.LittleEndian.PutUint32(block[4:8], 28)                   // block total length
+	binary.LittleEndian.PutUint32(block[8:12], 0x1A2B3C4D)          // byte-order magic
+	binary.LittleEndian.PutUint16(block[12:14], 1)                  // major version
+	binary.LittleEndian.PutUint16(block[14:16], 0)                  // minor version
+	binary.LittleEndian.PutUint64(block[16:24], 0xFFFFFFFFFFFFFFFF) // section length: -1 (unknown)
+	binary.LittleEndian.PutUint32(block[24:28], 28)                 // block total length (trailer)
+	t.W.Write(block)
+}
+
+// writeInterfaceDescription writes a minimal pcap-ng Interface Description
+// Block describing a single raw-Ethernet interface.
+func (t *PcapTracer) writeInterfaceDescription() {
+	block := make([]byte, 20)
+	binary.LittleEndian.PutUint32(block[0:4], 0x00000001) // block type
+	binary.LittleEndian.PutUint32(block[4:8], 20)         // block total length
+	binary.LittleEndian.PutUint16(block[8:10], 1)         // LinkType: Ethernet
+	binary.LittleEndian.PutUint16(block[10:12], 0)        // reserved
+	binary.LittleEndian.PutUint32(block[12:16], 65535)    // snap length
+	binary.LittleEndian.PutUint32(block[16:20], 20)       // block total length (trailer)
+	t.W.Write(block)
+}
+
+func (t *PcapTracer) writeEnhancedPacketBlock(frame []byte) {
+	padded := len(frame)
+	if rem := padded % 4; rem != 0 {
+		padded += 4 - rem
+	}
+	total := 32 + padded
+	block := make([]byte, total)
+	binary.LittleEndian.PutUint32(block[0:4], 0x00000006) // block type
+	binary.LittleEndian.PutUint32(block[4:8], uint32(total))
+	binary.LittleEndian.PutUint32(block[8:12], 0) // interface id
+	now := time.Now().UnixMicro()
+	binary.LittleEndian.PutUint32(block[12:16], uint32(now>>32))
+	binary.LittleEndian.PutUint32(block[16:20], uint32(now))
+	binary.LittleEndian.PutUint32(block[20:24], uint32(len(frame))) // captured length
+	binary.LittleEndian.PutUint32(block[24:28], uint32(len(frame))) // original length
+	copy(block[28:], frame)
+	binary.LittleEndian.PutUint32(block[total-4:total], uint32(total))
+	t.W.Write(block)
+}
+
+// buildEthernetIPv4TCP wraps payload in a synthetic (unvalidated checksum)
+// Ethernet/IPv4/TCP frame between src and dst, purely so Wireshark can
+// dissect the capture; no real link exists.
+func buildEthernetIPv4TCP(src, dst *net.TCPAddr, payload []byte, seq, ack uint32) []byte {
+	const ethHeaderLen = 14
+	const ipHeaderLen = 20
+	const tcpHeaderLen = 20
+
+	frame := make([]byte, ethHeaderLen+ipHeaderLen+tcpHeaderLen+len(payload))
+
+	// Ethernet header: zero MACs, EtherType IPv4.
+	binary.BigEndian.PutUint16(frame[12:14], 0x0800)
+
+	ipStart := ethHeaderLen
+	ip := frame[ipStart : ipStart+ipHeaderLen]
+	ip[0] = 0x45 // version 4, IHL 5
+	binary.BigEndian.PutUint16(ip[2:4], uint16(ipHeaderLen+tcpHeaderLen+len(payload)))
+	ip[8] = 64   // TTL
+	ip[9] = 0x06 // protocol: TCP
+	if src != nil {
+		copy(ip[12:16], src.IP.To4())
+	}
+	if dst != nil {
+		copy(ip[16:20], dst.IP.To4())
+	}
+
+	tcpStart := ipStart + ipHeaderLen
+	tcp := frame[tcpStart : tcpStart+tcpHeaderLen]
+	if src != nil {
+		binary.BigEndian.PutUint16(tcp[0:2], uint16(src.Port))
+	}
+	if dst != nil {
+		binary.BigEndian.PutUint16(tcp[2:4], uint16(dst.Port))
+	}
+	binary.BigEndian.PutUint32(tcp[4:8], seq)
+	binary.BigEndian.PutUint32(tcp[8:12], ack)
+	tcp[12] = 5 << 4 // data offset: 5 words
+	tcp[13] = 0x18   // flags: PSH, ACK
+
+	copy(frame[tcpStart+tcpHeaderLen:], payload)
+	return frame
+}
@@ -0,0 +1,55 @@
+package ed2k
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestHexTracerObservesCodecTraffic(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	var clientLog, serverLog bytes.Buffer
+	client := NewCodec(clientConn)
+	client.Tracer = &HexTracer{W: &clientLog}
+	server := NewCodec(serverConn)
+	server.Tracer = &HexTracer{W: &serverLog}
+
+	msg := &ServerStatusMessage{UserCount: 7, FileCount: 9}
+
+	done := make(chan error, 1)
+	go func() { done <- client.WriteMessage(msg) }()
+
+	if _, err := server.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+
+	if !strings.Contains(clientLog.String(), "[server-status]") {
+		t.Fatalf("client trace missing message header: %s", clientLog.String())
+	}
+	if !strings.Contains(serverLog.String(), "[server-status]") {
+		t.Fatalf("server trace missing message header: %s", serverLog.String())
+	}
+}
+
+func TestPcapTracerWritesValidSectionHeader(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := &PcapTracer{
+		W:          &buf,
+		LocalAddr:  &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4662},
+		RemoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 4661},
+	}
+
+	tracer.OnEncode(&GetServerListMessage{}, []byte{0xE3, 0x01, 0x00, 0x00, 0x00, MessageGetServerList})
+
+	out := buf.Bytes()
+	if len(out) < 4 || out[0] != 0x0A || out[1] != 0x0D || out[2] != 0x0D || out[3] != 0x0A {
+		t.Fatalf("missing pcap-ng section header magic, got % x", out[:4])
+	}
+}
@@ -0,0 +1,154 @@
+package udp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is used by UDPClient when Timeout is left zero.
+const DefaultTimeout = 5 * time.Second
+
+// UDPClient sends eD2k UDP requests and correlates the matching responses,
+// similar to how memberlist correlates indirect pings by sequence number.
+// Server-status requests are correlated by their challenge nonce; source
+// lookups are correlated by the file hash they asked about.
+type UDPClient struct {
+	conn    *net.UDPConn
+	Timeout time.Duration
+
+	mu            sync.Mutex
+	pendingStatus map[uint32]chan *UDPServerStatusResponseMessage
+	pendingSource map[[16]byte]chan *UDPGlobGetSourcesResultMessage
+	closed        chan struct{}
+}
+
+// NewUDPClient starts reading responses from conn in the background.
+// Call Close to stop the read loop and release resources.
+func NewUDPClient(conn *net.UDPConn) *UDPClient {
+	c := &UDPClient{
+		conn:          conn,
+		Timeout:       DefaultTimeout,
+		pendingStatus: make(map[uint32]chan *UDPServerStatusResponseMessage),
+		pendingSource: make(map[[16]byte]chan *UDPGlobGetSourcesResultMessage),
+		closed:        make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+// Close stops the background read loop and closes the underlying connection.
+func (c *UDPClient) Close() error {
+	close(c.closed)
+	return c.conn.Close()
+}
+
+func (c *UDPClient) readLoop() {
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		c.dispatch(append([]byte{}, buf[:n]...))
+	}
+}
+
+func (c *UDPClient) dispatch(data []byte) {
+	if len(data) < 2 {
+		return
+	}
+	switch data[1] {
+	case TypeServerStatusResponse:
+		resp := &UDPServerStatusResponseMessage{}
+		if err := resp.Decode(data); err != nil {
+			return
+		}
+		c.mu.Lock()
+		ch, ok := c.pendingStatus[resp.Challenge]
+		c.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	case TypeGlobGetSourcesResult:
+		resp := &UDPGlobGetSourcesResultMessage{}
+		if err := resp.Decode(data); err != nil {
+			return
+		}
+		c.mu.Lock()
+		ch, ok := c.pendingSource[resp.Hash]
+		c.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+// ServerStatus sends a UDPServerStatusRequestMessage with the given
+// challenge nonce to addr and waits up to Timeout for the matching
+// response.
+func (c *UDPClient) ServerStatus(addr *net.UDPAddr, challenge uint32) (*UDPServerStatusResponseMessage, error) {
+	ch := make(chan *UDPServerStatusResponseMessage, 1)
+	c.mu.Lock()
+	c.pendingStatus[challenge] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pendingStatus, challenge)
+		c.mu.Unlock()
+	}()
+
+	req := &UDPServerStatusRequestMessage{Challenge: challenge}
+	data, err := req.Encode()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.WriteToUDP(data, addr); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-time.After(c.timeout()):
+		return nil, fmt.Errorf("udp: timed out waiting for server status response from %s", addr)
+	}
+}
+
+// GlobGetSources sends a UDPGlobGetSourcesMessage for a single file hash to
+// addr and waits up to Timeout for the matching UDPGlobGetSourcesResultMessage.
+func (c *UDPClient) GlobGetSources(addr *net.UDPAddr, hash [16]byte) (*UDPGlobGetSourcesResultMessage, error) {
+	ch := make(chan *UDPGlobGetSourcesResultMessage, 1)
+	c.mu.Lock()
+	c.pendingSource[hash] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pendingSource, hash)
+		c.mu.Unlock()
+	}()
+
+	req := &UDPGlobGetSourcesMessage{Hashes: [][16]byte{hash}}
+	data, err := req.Encode()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.WriteToUDP(data, addr); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-time.After(c.timeout()):
+		return nil, fmt.Errorf("udp: timed out waiting for sources of %x from %s", hash, addr)
+	}
+}
+
+func (c *UDPClient) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return c.Timeout
+}
@@ -0,0 +1,328 @@
+// Package udp implements the eD2k UDP server protocol: status pings,
+// global search and source exchange. Unlike the TCP messages in the
+// parent ed2k package, UDP datagrams carry no length-prefixed header -
+// the datagram boundary is the frame boundary, so every message here is
+// simply [protocol byte][opcode byte][body].
+package udp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Protocol is the protocol byte used on eD2k UDP datagrams.
+const Protocol = 0xE3
+
+// eD2k UDP opcodes.
+const (
+	TypeServerStatusRequest   = 0x96
+	TypeServerStatusResponse  = 0x97
+	TypeGlobServerListRequest = 0x94
+	TypeGlobServerList        = 0x95
+	TypeGlobGetSources        = 0x9A
+	TypeGlobGetSourcesResult  = 0x9B
+)
+
+var (
+	// ErrShortBuffer is returned when a datagram is too small to hold the
+	// fields a message's Decode expects.
+	ErrShortBuffer = errors.New("udp: short buffer")
+	// ErrWrongMessageType is returned when Decode is called with a
+	// datagram whose opcode does not match the receiver type.
+	ErrWrongMessageType = errors.New("udp: wrong message type")
+)
+
+// Message is implemented by every eD2k UDP message type.
+type Message interface {
+	Encode() ([]byte, error)
+	Decode(data []byte) error
+	Type() uint8
+	String() string
+}
+
+// UDPServerStatusRequestMessage asks a server for its current user/file
+// counts. Challenge is a client-chosen random nonce echoed back in the
+// response so concurrent requests can be correlated.
+type UDPServerStatusRequestMessage struct {
+	Challenge uint32
+}
+
+// Encode encodes the message to binary data.
+func (m *UDPServerStatusRequestMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(Protocol)
+	buf.WriteByte(TypeServerStatusRequest)
+	if err = binary.Write(buf, binary.LittleEndian, m.Challenge); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes the message from binary data.
+func (m *UDPServerStatusRequestMessage) Decode(data []byte) error {
+	if len(data) < 6 {
+		return ErrShortBuffer
+	}
+	if data[1] != TypeServerStatusRequest {
+		return ErrWrongMessageType
+	}
+	m.Challenge = binary.LittleEndian.Uint32(data[2:6])
+	return nil
+}
+
+// Type is the message type
+func (m UDPServerStatusRequestMessage) Type() uint8 { return TypeServerStatusRequest }
+
+func (m UDPServerStatusRequestMessage) String() string {
+	return fmt.Sprintf("[udp-server-status-request]\nchallenge: %#x", m.Challenge)
+}
+
+// UDPServerStatusResponseMessage answers a UDPServerStatusRequestMessage,
+// echoing the client's challenge alongside the server's current user and
+// file counts.
+type UDPServerStatusResponseMessage struct {
+	Challenge uint32
+	UserCount uint32
+	FileCount uint32
+}
+
+// Encode encodes the message to binary data.
+func (m *UDPServerStatusResponseMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(Protocol)
+	buf.WriteByte(TypeServerStatusResponse)
+	if err = binary.Write(buf, binary.LittleEndian, m.Challenge); err != nil {
+		return nil, err
+	}
+	if err = binary.Write(buf, binary.LittleEndian, m.UserCount); err != nil {
+		return nil, err
+	}
+	if err = binary.Write(buf, binary.LittleEndian, m.FileCount); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes the message from binary data.
+func (m *UDPServerStatusResponseMessage) Decode(data []byte) error {
+	if len(data) < 14 {
+		return ErrShortBuffer
+	}
+	if data[1] != TypeServerStatusResponse {
+		return ErrWrongMessageType
+	}
+	m.Challenge = binary.LittleEndian.Uint32(data[2:6])
+	m.UserCount = binary.LittleEndian.Uint32(data[6:10])
+	m.FileCount = binary.LittleEndian.Uint32(data[10:14])
+	return nil
+}
+
+// Type is the message type
+func (m UDPServerStatusResponseMessage) Type() uint8 { return TypeServerStatusResponse }
+
+func (m UDPServerStatusResponseMessage) String() string {
+	return fmt.Sprintf("[udp-server-status-response]\nchallenge: %#x, users: %d, files: %d", m.Challenge, m.UserCount, m.FileCount)
+}
+
+// UDPGlobGetSourcesMessage asks a server for the sources of one or more
+// files, identified by their eD2k hash.
+type UDPGlobGetSourcesMessage struct {
+	Hashes [][16]byte
+}
+
+// Encode encodes the message to binary data.
+func (m *UDPGlobGetSourcesMessage) Encode() (data []byte, err error) {
+	if len(m.Hashes) > 255 {
+		return nil, fmt.Errorf("udp: too many hashes (%d), max 255", len(m.Hashes))
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteByte(Protocol)
+	buf.WriteByte(TypeGlobGetSources)
+	buf.WriteByte(byte(len(m.Hashes)))
+	for _, h := range m.Hashes {
+		buf.Write(h[:])
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes the message from binary data.
+func (m *UDPGlobGetSourcesMessage) Decode(data []byte) error {
+	if len(data) < 3 {
+		return ErrShortBuffer
+	}
+	if data[1] != TypeGlobGetSources {
+		return ErrWrongMessageType
+	}
+	count := int(data[2])
+	pos := 3
+	if len(data) < pos+count*16 {
+		return ErrShortBuffer
+	}
+	m.Hashes = nil
+	for i := 0; i < count; i++ {
+		var h [16]byte
+		copy(h[:], data[pos:pos+16])
+		m.Hashes = append(m.Hashes, h)
+		pos += 16
+	}
+	return nil
+}
+
+// Type is the message type
+func (m UDPGlobGetSourcesMessage) Type() uint8 { return TypeGlobGetSources }
+
+func (m UDPGlobGetSourcesMessage) String() string {
+	return fmt.Sprintf("[udp-glob-get-sources]\nhashes: %d", len(m.Hashes))
+}
+
+// UDPGlobGetSourcesResultMessage answers a UDPGlobGetSourcesMessage for a
+// single file hash with the (IP, port) of every known source.
+type UDPGlobGetSourcesResultMessage struct {
+	Hash    [16]byte
+	Sources []*net.UDPAddr
+}
+
+// Encode encodes the message to binary data.
+func (m *UDPGlobGetSourcesResultMessage) Encode() (data []byte, err error) {
+	if len(m.Sources) > 255 {
+		return nil, fmt.Errorf("udp: too many sources (%d), max 255", len(m.Sources))
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteByte(Protocol)
+	buf.WriteByte(TypeGlobGetSourcesResult)
+	buf.Write(m.Hash[:])
+	buf.WriteByte(byte(len(m.Sources)))
+	for _, src := range m.Sources {
+		if src == nil {
+			src = &net.UDPAddr{IP: net.IPv4zero, Port: 0}
+		}
+		buf.Write(src.IP.To4())
+		if err = binary.Write(buf, binary.LittleEndian, uint16(src.Port)); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes the message from binary data.
+func (m *UDPGlobGetSourcesResultMessage) Decode(data []byte) error {
+	if len(data) < 19 {
+		return ErrShortBuffer
+	}
+	if data[1] != TypeGlobGetSourcesResult {
+		return ErrWrongMessageType
+	}
+	copy(m.Hash[:], data[2:18])
+	count := int(data[18])
+	pos := 19
+	if len(data) < pos+count*6 {
+		return ErrShortBuffer
+	}
+	m.Sources = nil
+	for i := 0; i < count; i++ {
+		ip := make(net.IP, 4)
+		copy(ip, data[pos:pos+4])
+		m.Sources = append(m.Sources, &net.UDPAddr{
+			IP:   ip,
+			Port: int(binary.LittleEndian.Uint16(data[pos+4 : pos+6])),
+		})
+		pos += 6
+	}
+	return nil
+}
+
+// Type is the message type
+func (m UDPGlobGetSourcesResultMessage) Type() uint8 { return TypeGlobGetSourcesResult }
+
+func (m UDPGlobGetSourcesResultMessage) String() string {
+	return fmt.Sprintf("[udp-glob-get-sources-result]\nhash: %X, sources: %d", m.Hash, len(m.Sources))
+}
+
+// UDPGlobServerListRequestMessage asks a server for its list of known
+// eMule servers over UDP, analogous to GetServerListMessage over TCP.
+type UDPGlobServerListRequestMessage struct{}
+
+// Encode encodes the message to binary data.
+func (m *UDPGlobServerListRequestMessage) Encode() ([]byte, error) {
+	return []byte{Protocol, TypeGlobServerListRequest}, nil
+}
+
+// Decode decodes the message from binary data.
+func (m *UDPGlobServerListRequestMessage) Decode(data []byte) error {
+	if len(data) < 2 {
+		return ErrShortBuffer
+	}
+	if data[1] != TypeGlobServerListRequest {
+		return ErrWrongMessageType
+	}
+	return nil
+}
+
+// Type is the message type
+func (m UDPGlobServerListRequestMessage) Type() uint8 { return TypeGlobServerListRequest }
+
+func (m UDPGlobServerListRequestMessage) String() string {
+	return "[udp-glob-server-list-request]"
+}
+
+// UDPGlobServerListMessage answers a UDPGlobServerListRequestMessage with
+// a list of eMule server descriptors, mirroring ed2k.ServerListMessage's
+// 6-byte (IPv4, port) entries.
+type UDPGlobServerListMessage struct {
+	Servers []*net.TCPAddr
+}
+
+// Encode encodes the message to binary data.
+func (m *UDPGlobServerListMessage) Encode() (data []byte, err error) {
+	if len(m.Servers) > 255 {
+		return nil, fmt.Errorf("udp: too many servers (%d), max 255", len(m.Servers))
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteByte(Protocol)
+	buf.WriteByte(TypeGlobServerList)
+	buf.WriteByte(byte(len(m.Servers)))
+	for _, addr := range m.Servers {
+		if addr == nil {
+			addr = &net.TCPAddr{IP: net.IPv4zero, Port: 0}
+		}
+		buf.Write(addr.IP.To4())
+		if err = binary.Write(buf, binary.LittleEndian, uint16(addr.Port)); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes the message from binary data.
+func (m *UDPGlobServerListMessage) Decode(data []byte) error {
+	if len(data) < 3 {
+		return ErrShortBuffer
+	}
+	if data[1] != TypeGlobServerList {
+		return ErrWrongMessageType
+	}
+	count := int(data[2])
+	pos := 3
+	if len(data) < pos+count*6 {
+		return ErrShortBuffer
+	}
+	m.Servers = nil
+	for i := 0; i < count; i++ {
+		m.Servers = append(m.Servers, &net.TCPAddr{
+			IP:   net.IP(data[pos : pos+4]),
+			Port: int(binary.LittleEndian.Uint16(data[pos+4 : pos+6])),
+		})
+		pos += 6
+	}
+	return nil
+}
+
+// Type is the message type
+func (m UDPGlobServerListMessage) Type() uint8 { return TypeGlobServerList }
+
+func (m UDPGlobServerListMessage) String() string {
+	return fmt.Sprintf("[udp-glob-server-list]\nservers: %d", len(m.Servers))
+}
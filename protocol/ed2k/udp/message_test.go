@@ -0,0 +1,90 @@
+package udp
+
+import (
+	"net"
+	"testing"
+)
+
+func TestUDPServerStatusRoundTrip(t *testing.T) {
+	req := &UDPServerStatusRequestMessage{Challenge: 0xdeadbeef}
+	data, err := req.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	got := &UDPServerStatusRequestMessage{}
+	if err := got.Decode(data); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Challenge != req.Challenge {
+		t.Fatalf("challenge = %#x, want %#x", got.Challenge, req.Challenge)
+	}
+
+	resp := &UDPServerStatusResponseMessage{Challenge: req.Challenge, UserCount: 100, FileCount: 5000}
+	data, err = resp.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	gotResp := &UDPServerStatusResponseMessage{}
+	if err := gotResp.Decode(data); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if gotResp.Challenge != resp.Challenge || gotResp.UserCount != resp.UserCount || gotResp.FileCount != resp.FileCount {
+		t.Fatalf("got %+v, want %+v", gotResp, resp)
+	}
+}
+
+func TestUDPGlobGetSourcesRoundTrip(t *testing.T) {
+	hash := [16]byte{1, 2, 3, 4}
+	req := &UDPGlobGetSourcesMessage{Hashes: [][16]byte{hash}}
+	data, err := req.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	got := &UDPGlobGetSourcesMessage{}
+	if err := got.Decode(data); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(got.Hashes) != 1 || got.Hashes[0] != hash {
+		t.Fatalf("hashes = %v, want [%v]", got.Hashes, hash)
+	}
+}
+
+func TestUDPGlobGetSourcesResultDecodeDoesNotAliasInputBuffer(t *testing.T) {
+	m := &UDPGlobGetSourcesResultMessage{
+		Hash:    [16]byte{1, 2, 3, 4},
+		Sources: []*net.UDPAddr{{IP: net.IPv4(10, 0, 0, 1), Port: 4662}},
+	}
+	data, err := m.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	got := &UDPGlobGetSourcesResultMessage{}
+	if err := got.Decode(data); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := got.Sources[0].IP.String()
+
+	// Simulate readLoop reusing its buffer for the next datagram.
+	for i := range data {
+		data[i] = 0xff
+	}
+	if got := got.Sources[0].IP.String(); got != want {
+		t.Fatalf("Sources[0].IP changed after overwriting the decoded buffer: got %s, want %s", got, want)
+	}
+}
+
+func TestUDPGlobServerListRoundTrip(t *testing.T) {
+	m := &UDPGlobServerListMessage{}
+	data, err := m.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	got := &UDPGlobServerListMessage{}
+	if err := got.Decode(data); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(got.Servers) != 0 {
+		t.Fatalf("servers = %d, want 0", len(got.Servers))
+	}
+}
@@ -0,0 +1,16 @@
+package ed2k
+
+import "encoding/hex"
+
+// UID is a client's randomly-generated 128-bit identifier, sent in
+// LoginMessage and stable for the lifetime of the client installation.
+type UID [16]byte
+
+// Bytes returns the UID's raw bytes.
+func (u UID) Bytes() []byte {
+	return u[:]
+}
+
+func (u UID) String() string {
+	return hex.EncodeToString(u[:])
+}
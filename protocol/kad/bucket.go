@@ -0,0 +1,147 @@
+package kad
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BucketSize is k, the maximum number of contacts held per k-bucket,
+// matching eMule's default.
+const BucketSize = 10
+
+// NumBuckets is one bucket per bit of the 128-bit ID space.
+const NumBuckets = IDLength * 8
+
+// Contact is a known Kad node: its ID, UDP address (used for Kad RPCs) and
+// TCP port (used if a subsequent direct connection is needed).
+type Contact struct {
+	ID       ID
+	UDPAddr  *net.UDPAddr
+	TCPPort  uint16
+	LastSeen time.Time
+}
+
+// bucket holds up to BucketSize contacts ordered least-recently-seen first,
+// the classic Kademlia eviction order: a new contact is only added while the
+// bucket has room, and a full bucket gains room only when a member is
+// removed (see RoutingTable.Remove).
+type bucket struct {
+	contacts []Contact
+}
+
+func (b *bucket) upsert(c Contact) {
+	for i := range b.contacts {
+		if b.contacts[i].ID == c.ID {
+			b.contacts = append(b.contacts[:i], b.contacts[i+1:]...)
+			b.contacts = append(b.contacts, c)
+			return
+		}
+	}
+	if len(b.contacts) < BucketSize {
+		b.contacts = append(b.contacts, c)
+	}
+	// A full bucket silently drops a new contact that is not already a
+	// member; neither the bucket nor Node pings the least-recently-seen
+	// entry to make room for it.
+}
+
+func (b *bucket) remove(id ID) {
+	for i := range b.contacts {
+		if b.contacts[i].ID == id {
+			b.contacts = append(b.contacts[:i], b.contacts[i+1:]...)
+			return
+		}
+	}
+}
+
+// RoutingTable is a 128-bit-ID Kademlia routing table: NumBuckets k-buckets
+// indexed by the length of the shared ID prefix with Self.
+type RoutingTable struct {
+	Self ID
+
+	mu           sync.RWMutex
+	buckets      [NumBuckets]bucket
+	lastActivity [NumBuckets]time.Time
+}
+
+// NewRoutingTable returns an empty routing table owned by the node with ID self.
+func NewRoutingTable(self ID) *RoutingTable {
+	return &RoutingTable{Self: self}
+}
+
+func (rt *RoutingTable) bucketIndex(id ID) int {
+	idx := Distance(rt.Self, id).PrefixLength()
+	if idx >= NumBuckets {
+		idx = NumBuckets - 1
+	}
+	return idx
+}
+
+// Insert records c as seen, adding it to its bucket if there is room.
+func (rt *RoutingTable) Insert(c Contact) {
+	if c.ID == rt.Self {
+		return
+	}
+	c.LastSeen = time.Now()
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	idx := rt.bucketIndex(c.ID)
+	rt.buckets[idx].upsert(c)
+	rt.lastActivity[idx] = c.LastSeen
+}
+
+// StaleBuckets returns the indexes of buckets that have had no contact
+// inserted within maxAge, i.e. the candidates RefreshBucket should target
+// to keep distant parts of the ID space populated.
+func (rt *RoutingTable) StaleBuckets(maxAge time.Duration) []int {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	cutoff := time.Now().Add(-maxAge)
+	var stale []int
+	for i := range rt.buckets {
+		if rt.lastActivity[i].Before(cutoff) {
+			stale = append(stale, i)
+		}
+	}
+	return stale
+}
+
+// Remove drops id from the table, e.g. after it fails to respond to a ping.
+func (rt *RoutingTable) Remove(id ID) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.buckets[rt.bucketIndex(id)].remove(id)
+}
+
+// Closest returns up to count contacts ordered by increasing Kad distance
+// to target, gathered from every bucket and sorted.
+func (rt *RoutingTable) Closest(target ID, count int) []Contact {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	var all []Contact
+	for i := range rt.buckets {
+		all = append(all, rt.buckets[i].contacts...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return Distance(target, all[i].ID).Less(Distance(target, all[j].ID))
+	})
+	if len(all) > count {
+		all = all[:count]
+	}
+	return all
+}
+
+// Contacts returns every contact currently known to the table, for
+// persistence to a nodes.dat-style file.
+func (rt *RoutingTable) Contacts() []Contact {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	var all []Contact
+	for i := range rt.buckets {
+		all = append(all, rt.buckets[i].contacts...)
+	}
+	return all
+}
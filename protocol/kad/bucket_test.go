@@ -0,0 +1,54 @@
+package kad
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRoutingTableClosestOrdersByDistance(t *testing.T) {
+	self := ID{0x00}
+	rt := NewRoutingTable(self)
+
+	far := ID{0xFF}
+	near := ID{0x01}
+	mid := ID{0x10}
+
+	for _, id := range []ID{far, near, mid} {
+		rt.Insert(Contact{ID: id, UDPAddr: &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 4672}})
+	}
+
+	target := ID{0x00}
+	closest := rt.Closest(target, 2)
+	if len(closest) != 2 {
+		t.Fatalf("len(closest) = %d, want 2", len(closest))
+	}
+	if closest[0].ID != near {
+		t.Fatalf("closest[0] = %v, want %v", closest[0].ID, near)
+	}
+	if closest[1].ID != mid {
+		t.Fatalf("closest[1] = %v, want %v", closest[1].ID, mid)
+	}
+}
+
+func TestRoutingTableIgnoresSelf(t *testing.T) {
+	self := ID{0x42}
+	rt := NewRoutingTable(self)
+	rt.Insert(Contact{ID: self, UDPAddr: &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 4672}})
+	if len(rt.Contacts()) != 0 {
+		t.Fatalf("expected self not to be inserted into its own routing table")
+	}
+}
+
+func TestBucketEvictsOnRemove(t *testing.T) {
+	self := ID{0x00}
+	rt := NewRoutingTable(self)
+	c := Contact{ID: ID{0x01}, UDPAddr: &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 4672}}
+	rt.Insert(c)
+	if len(rt.Contacts()) != 1 {
+		t.Fatalf("expected 1 contact after insert")
+	}
+	rt.Remove(c.ID)
+	if len(rt.Contacts()) != 0 {
+		t.Fatalf("expected 0 contacts after remove")
+	}
+}
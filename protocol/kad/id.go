@@ -0,0 +1,95 @@
+// Package kad implements the Kademlia (Kad) overlay that eMule clients
+// fall back to for serverless operation: a 128-bit-ID routing table, the
+// Kad2 bootstrap/hello/firewall-check/search RPCs, and iterative node
+// lookups.
+package kad
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+)
+
+// IDLength is the length, in bytes, of a Kad node or key ID.
+const IDLength = 16
+
+// ID is a 128-bit Kad node or key identifier.
+type ID [IDLength]byte
+
+// String renders the ID as hex, matching how file/client hashes are
+// printed elsewhere in this module.
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// RandomID returns a cryptographically random 128-bit ID, suitable as a
+// node's own identity or as a throwaway search target.
+func RandomID() (ID, error) {
+	var id ID
+	if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
+		return ID{}, err
+	}
+	return id, nil
+}
+
+// Distance returns the Kad XOR distance between a and b.
+func Distance(a, b ID) ID {
+	var d ID
+	for i := range d {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
+
+// Less reports whether a is strictly closer to nothing in particular but
+// numerically less than b, when both are treated as big-endian 128-bit
+// integers. It is used to order candidates by distance once Distance has
+// been applied.
+func (a ID) Less(b ID) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// RandomIDInBucket returns a random ID whose XOR distance from self has
+// exactly bucketIndex leading zero bits, i.e. an ID that falls in the
+// k-bucket at that index. This is the target a bucket refresh looks up.
+func RandomIDInBucket(self ID, bucketIndex int) (ID, error) {
+	if bucketIndex < 0 {
+		bucketIndex = 0
+	}
+	if bucketIndex >= IDLength*8 {
+		bucketIndex = IDLength*8 - 1
+	}
+	var id ID
+	if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
+		return ID{}, err
+	}
+	byteIdx := bucketIndex / 8
+	bitIdx := uint(bucketIndex % 8)
+	flipMask := byte(0x80 >> bitIdx)
+	keepMask := byte(0xFF) << (8 - bitIdx)
+
+	copy(id[:byteIdx], self[:byteIdx])
+	id[byteIdx] = (self[byteIdx] & keepMask) | ((self[byteIdx] ^ flipMask) & flipMask) | (id[byteIdx] &^ (keepMask | flipMask))
+	return id, nil
+}
+
+// PrefixLength returns the number of leading zero bits in the ID, i.e. the
+// k-bucket index a contact with this ID-as-distance belongs in.
+func (id ID) PrefixLength() int {
+	for i, b := range id {
+		if b == 0 {
+			continue
+		}
+		for bit := 0; bit < 8; bit++ {
+			if b&(0x80>>uint(bit)) != 0 {
+				return i*8 + bit
+			}
+		}
+	}
+	return IDLength * 8
+}
@@ -0,0 +1,50 @@
+package kad
+
+import "testing"
+
+func TestDistanceIsZeroForSameID(t *testing.T) {
+	id, err := RandomID()
+	if err != nil {
+		t.Fatalf("RandomID: %v", err)
+	}
+	d := Distance(id, id)
+	if d.PrefixLength() != IDLength*8 {
+		d2 := d
+		t.Fatalf("distance(id, id) = %x, want all zero", d2)
+	}
+}
+
+func TestPrefixLength(t *testing.T) {
+	var id ID
+	id[0] = 0x0F // 0000 1111
+	if got, want := id.PrefixLength(), 4; got != want {
+		t.Fatalf("PrefixLength() = %d, want %d", got, want)
+	}
+}
+
+func TestRandomIDInBucket(t *testing.T) {
+	self, err := RandomID()
+	if err != nil {
+		t.Fatalf("RandomID: %v", err)
+	}
+	for _, index := range []int{0, 1, 7, 8, 63, 127} {
+		id, err := RandomIDInBucket(self, index)
+		if err != nil {
+			t.Fatalf("RandomIDInBucket(%d): %v", index, err)
+		}
+		if got := Distance(self, id).PrefixLength(); got != index {
+			t.Fatalf("RandomIDInBucket(%d): distance prefix length = %d, want %d", index, got, index)
+		}
+	}
+}
+
+func TestLessOrdersByMagnitude(t *testing.T) {
+	a := ID{0x00, 0x01}
+	b := ID{0x00, 0x02}
+	if !a.Less(b) {
+		t.Fatalf("expected %v < %v", a, b)
+	}
+	if b.Less(a) {
+		t.Fatalf("did not expect %v < %v", b, a)
+	}
+}
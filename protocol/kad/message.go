@@ -0,0 +1,422 @@
+package kad
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/eyedeekay/gmule-core/protocol/ed2k"
+)
+
+// Protocol is the protocol byte eMule's Kad2 overlay uses on UDP datagrams,
+// distinguishing Kad traffic from the plain eD2k UDP messages in the udp
+// package.
+const Protocol = 0xE4
+
+// Kad2 opcodes.
+const (
+	OpBootstrapRequest   = 0x01
+	OpBootstrapResponse  = 0x09
+	OpHelloRequest       = 0x11
+	OpHelloResponse      = 0x19
+	OpFirewalledRequest  = 0x50
+	OpFirewalledResponse = 0x58
+	OpSearchRequest      = 0x33
+	OpSearchResponse     = 0x3B
+)
+
+// SearchKind selects whether a SearchRequestMessage is a FIND_NODE lookup
+// (closest contacts to Target) or a FIND_VALUE lookup (keyword/source
+// search for Target as a content hash).
+type SearchKind uint8
+
+const (
+	SearchFindNode SearchKind = iota
+	SearchFindValue
+)
+
+var (
+	// ErrShortBuffer is returned when a datagram is too small to hold the
+	// fields a message's Decode expects.
+	ErrShortBuffer = errors.New("kad: short buffer")
+	// ErrWrongMessageType is returned when Decode is called with a
+	// datagram whose opcode does not match the receiver type.
+	ErrWrongMessageType = errors.New("kad: wrong message type")
+)
+
+// Message is implemented by every Kad2 message type.
+type Message interface {
+	Encode() ([]byte, error)
+	Decode(data []byte) error
+	Type() uint8
+	String() string
+}
+
+func writeContact(buf *bytes.Buffer, c Contact) error {
+	buf.Write(c.ID[:])
+	ip := net.IPv4zero
+	port := 0
+	if c.UDPAddr != nil {
+		ip = c.UDPAddr.IP
+		port = c.UDPAddr.Port
+	}
+	buf.Write(ip.To4())
+	if err := binary.Write(buf, binary.LittleEndian, uint16(port)); err != nil {
+		return err
+	}
+	return binary.Write(buf, binary.LittleEndian, c.TCPPort)
+}
+
+const contactWireSize = IDLength + 4 + 2 + 2
+
+func readContact(data []byte) (Contact, error) {
+	if len(data) < contactWireSize {
+		return Contact{}, ErrShortBuffer
+	}
+	var c Contact
+	copy(c.ID[:], data[:IDLength])
+	pos := IDLength
+	c.UDPAddr = &net.UDPAddr{
+		IP:   net.IP(data[pos : pos+4]),
+		Port: int(binary.LittleEndian.Uint16(data[pos+4 : pos+6])),
+	}
+	pos += 6
+	c.TCPPort = binary.LittleEndian.Uint16(data[pos : pos+2])
+	return c, nil
+}
+
+// HelloRequestMessage announces a node's presence to a peer it has just
+// learned about, exchanging IDs and TCP ports.
+type HelloRequestMessage struct {
+	ID      ID
+	TCPPort uint16
+}
+
+func (m *HelloRequestMessage) Encode() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(Protocol)
+	buf.WriteByte(OpHelloRequest)
+	buf.Write(m.ID[:])
+	if err := binary.Write(buf, binary.LittleEndian, m.TCPPort); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *HelloRequestMessage) Decode(data []byte) error {
+	if len(data) < 2+IDLength+2 {
+		return ErrShortBuffer
+	}
+	if data[1] != OpHelloRequest {
+		return ErrWrongMessageType
+	}
+	copy(m.ID[:], data[2:2+IDLength])
+	m.TCPPort = binary.LittleEndian.Uint16(data[2+IDLength : 4+IDLength])
+	return nil
+}
+
+func (m HelloRequestMessage) Type() uint8 { return OpHelloRequest }
+func (m HelloRequestMessage) String() string {
+	return fmt.Sprintf("[kad-hello-request]\nid: %s, tcpPort: %d", m.ID, m.TCPPort)
+}
+
+// HelloResponseMessage answers a HelloRequestMessage with the same fields.
+type HelloResponseMessage struct {
+	ID      ID
+	TCPPort uint16
+}
+
+func (m *HelloResponseMessage) Encode() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(Protocol)
+	buf.WriteByte(OpHelloResponse)
+	buf.Write(m.ID[:])
+	if err := binary.Write(buf, binary.LittleEndian, m.TCPPort); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *HelloResponseMessage) Decode(data []byte) error {
+	if len(data) < 2+IDLength+2 {
+		return ErrShortBuffer
+	}
+	if data[1] != OpHelloResponse {
+		return ErrWrongMessageType
+	}
+	copy(m.ID[:], data[2:2+IDLength])
+	m.TCPPort = binary.LittleEndian.Uint16(data[2+IDLength : 4+IDLength])
+	return nil
+}
+
+func (m HelloResponseMessage) Type() uint8 { return OpHelloResponse }
+func (m HelloResponseMessage) String() string {
+	return fmt.Sprintf("[kad-hello-response]\nid: %s, tcpPort: %d", m.ID, m.TCPPort)
+}
+
+// BootstrapRequestMessage asks a known contact to seed our routing table
+// with its own view of the network.
+type BootstrapRequestMessage struct{}
+
+func (m *BootstrapRequestMessage) Encode() ([]byte, error) {
+	return []byte{Protocol, OpBootstrapRequest}, nil
+}
+
+func (m *BootstrapRequestMessage) Decode(data []byte) error {
+	if len(data) < 2 {
+		return ErrShortBuffer
+	}
+	if data[1] != OpBootstrapRequest {
+		return ErrWrongMessageType
+	}
+	return nil
+}
+
+func (m BootstrapRequestMessage) Type() uint8    { return OpBootstrapRequest }
+func (m BootstrapRequestMessage) String() string { return "[kad-bootstrap-request]" }
+
+// BootstrapResponseMessage answers a BootstrapRequestMessage with the
+// responder's own ID/TCP port and a sample of contacts from its routing
+// table to seed the requester's.
+type BootstrapResponseMessage struct {
+	ID       ID
+	TCPPort  uint16
+	Contacts []Contact
+}
+
+func (m *BootstrapResponseMessage) Encode() ([]byte, error) {
+	if len(m.Contacts) > 255 {
+		return nil, fmt.Errorf("kad: too many contacts (%d), max 255", len(m.Contacts))
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteByte(Protocol)
+	buf.WriteByte(OpBootstrapResponse)
+	buf.Write(m.ID[:])
+	if err := binary.Write(buf, binary.LittleEndian, m.TCPPort); err != nil {
+		return nil, err
+	}
+	buf.WriteByte(byte(len(m.Contacts)))
+	for _, c := range m.Contacts {
+		if err := writeContact(buf, c); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *BootstrapResponseMessage) Decode(data []byte) error {
+	if len(data) < 2+IDLength+3 {
+		return ErrShortBuffer
+	}
+	if data[1] != OpBootstrapResponse {
+		return ErrWrongMessageType
+	}
+	copy(m.ID[:], data[2:2+IDLength])
+	pos := 2 + IDLength
+	m.TCPPort = binary.LittleEndian.Uint16(data[pos : pos+2])
+	pos += 2
+	count := int(data[pos])
+	pos++
+	m.Contacts = nil
+	for i := 0; i < count; i++ {
+		if len(data) < pos+contactWireSize {
+			return ErrShortBuffer
+		}
+		c, err := readContact(data[pos:])
+		if err != nil {
+			return err
+		}
+		m.Contacts = append(m.Contacts, c)
+		pos += contactWireSize
+	}
+	return nil
+}
+
+func (m BootstrapResponseMessage) Type() uint8 { return OpBootstrapResponse }
+func (m BootstrapResponseMessage) String() string {
+	return fmt.Sprintf("[kad-bootstrap-response]\nid: %s, contacts: %d", m.ID, len(m.Contacts))
+}
+
+// FirewalledRequestMessage asks a peer to report whether it could reach us
+// directly, letting a node detect whether it sits behind a firewall/NAT
+// that blocks incoming TCP.
+type FirewalledRequestMessage struct {
+	TCPPort uint16
+}
+
+func (m *FirewalledRequestMessage) Encode() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(Protocol)
+	buf.WriteByte(OpFirewalledRequest)
+	if err := binary.Write(buf, binary.LittleEndian, m.TCPPort); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *FirewalledRequestMessage) Decode(data []byte) error {
+	if len(data) < 4 {
+		return ErrShortBuffer
+	}
+	if data[1] != OpFirewalledRequest {
+		return ErrWrongMessageType
+	}
+	m.TCPPort = binary.LittleEndian.Uint16(data[2:4])
+	return nil
+}
+
+func (m FirewalledRequestMessage) Type() uint8 { return OpFirewalledRequest }
+func (m FirewalledRequestMessage) String() string {
+	return fmt.Sprintf("[kad-firewalled-request]\ntcpPort: %d", m.TCPPort)
+}
+
+// FirewalledResponseMessage reports the result of the connectivity check a
+// FirewalledRequestMessage asked for.
+type FirewalledResponseMessage struct {
+	Firewalled bool
+}
+
+func (m *FirewalledResponseMessage) Encode() ([]byte, error) {
+	flag := byte(0)
+	if m.Firewalled {
+		flag = 1
+	}
+	return []byte{Protocol, OpFirewalledResponse, flag}, nil
+}
+
+func (m *FirewalledResponseMessage) Decode(data []byte) error {
+	if len(data) < 3 {
+		return ErrShortBuffer
+	}
+	if data[1] != OpFirewalledResponse {
+		return ErrWrongMessageType
+	}
+	m.Firewalled = data[2] != 0
+	return nil
+}
+
+func (m FirewalledResponseMessage) Type() uint8 { return OpFirewalledResponse }
+func (m FirewalledResponseMessage) String() string {
+	return fmt.Sprintf("[kad-firewalled-response]\nfirewalled: %v", m.Firewalled)
+}
+
+// SearchRequestMessage performs an iterative-lookup step: FIND_NODE asks
+// for the contacts a peer knows that are closest to Target; FIND_VALUE
+// asks for file sources/keyword matches for Target as a content hash,
+// falling back to FIND_NODE-style contacts if the peer has no match.
+type SearchRequestMessage struct {
+	Target ID
+	Kind   SearchKind
+}
+
+func (m *SearchRequestMessage) Encode() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(Protocol)
+	buf.WriteByte(OpSearchRequest)
+	buf.Write(m.Target[:])
+	buf.WriteByte(byte(m.Kind))
+	return buf.Bytes(), nil
+}
+
+func (m *SearchRequestMessage) Decode(data []byte) error {
+	if len(data) < 2+IDLength+1 {
+		return ErrShortBuffer
+	}
+	if data[1] != OpSearchRequest {
+		return ErrWrongMessageType
+	}
+	copy(m.Target[:], data[2:2+IDLength])
+	m.Kind = SearchKind(data[2+IDLength])
+	return nil
+}
+
+func (m SearchRequestMessage) Type() uint8 { return OpSearchRequest }
+func (m SearchRequestMessage) String() string {
+	return fmt.Sprintf("[kad-search-request]\ntarget: %s, kind: %d", m.Target, m.Kind)
+}
+
+// SearchResponseMessage answers a SearchRequestMessage. Contacts carries
+// the FIND_NODE-style closest contacts; Files carries FIND_VALUE matches,
+// reusing ed2k.File (and its embedded ed2k.Tag list) so a search result's
+// shape matches ed2k.SearchResultMessage.
+type SearchResponseMessage struct {
+	Target   ID
+	Contacts []Contact
+	Files    []ed2k.File
+}
+
+func (m *SearchResponseMessage) Encode() ([]byte, error) {
+	if len(m.Contacts) > 255 || len(m.Files) > 255 {
+		return nil, fmt.Errorf("kad: too many results to encode")
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteByte(Protocol)
+	buf.WriteByte(OpSearchResponse)
+	buf.Write(m.Target[:])
+
+	buf.WriteByte(byte(len(m.Contacts)))
+	for _, c := range m.Contacts {
+		if err := writeContact(buf, c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(m.Files))); err != nil {
+		return nil, err
+	}
+	for _, f := range m.Files {
+		if _, err := f.WriteTo(buf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *SearchResponseMessage) Decode(data []byte) error {
+	if len(data) < 2+IDLength+1 {
+		return ErrShortBuffer
+	}
+	if data[1] != OpSearchResponse {
+		return ErrWrongMessageType
+	}
+	copy(m.Target[:], data[2:2+IDLength])
+	pos := 2 + IDLength
+
+	contactCount := int(data[pos])
+	pos++
+	m.Contacts = nil
+	for i := 0; i < contactCount; i++ {
+		if len(data) < pos+contactWireSize {
+			return ErrShortBuffer
+		}
+		c, err := readContact(data[pos:])
+		if err != nil {
+			return err
+		}
+		m.Contacts = append(m.Contacts, c)
+		pos += contactWireSize
+	}
+
+	if len(data) < pos+4 {
+		return ErrShortBuffer
+	}
+	fileCount := binary.LittleEndian.Uint32(data[pos : pos+4])
+	pos += 4
+	r := bytes.NewReader(data[pos:])
+	m.Files = nil
+	for i := 0; i < int(fileCount); i++ {
+		f, err := ed2k.ReadFile(r)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, *f)
+	}
+	return nil
+}
+
+func (m SearchResponseMessage) Type() uint8 { return OpSearchResponse }
+func (m SearchResponseMessage) String() string {
+	return fmt.Sprintf("[kad-search-response]\ntarget: %s, contacts: %d, files: %d", m.Target, len(m.Contacts), len(m.Files))
+}
@@ -0,0 +1,87 @@
+package kad
+
+import (
+	"net"
+	"testing"
+
+	"github.com/eyedeekay/gmule-core/protocol/ed2k"
+)
+
+func TestHelloRoundTrip(t *testing.T) {
+	id, _ := RandomID()
+	req := &HelloRequestMessage{ID: id, TCPPort: 4672}
+	data, err := req.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	got := &HelloRequestMessage{}
+	if err := got.Decode(data); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.ID != req.ID || got.TCPPort != req.TCPPort {
+		t.Fatalf("got %+v, want %+v", got, req)
+	}
+}
+
+func TestBootstrapResponseRoundTrip(t *testing.T) {
+	selfID, _ := RandomID()
+	contactID, _ := RandomID()
+	resp := &BootstrapResponseMessage{
+		ID:      selfID,
+		TCPPort: 4672,
+		Contacts: []Contact{
+			{ID: contactID, UDPAddr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}, TCPPort: 4662},
+		},
+	}
+	data, err := resp.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	got := &BootstrapResponseMessage{}
+	if err := got.Decode(data); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.ID != resp.ID || got.TCPPort != resp.TCPPort || len(got.Contacts) != 1 {
+		t.Fatalf("got %+v, want %+v", got, resp)
+	}
+	if got.Contacts[0].ID != contactID || got.Contacts[0].TCPPort != 4662 {
+		t.Fatalf("contact = %+v", got.Contacts[0])
+	}
+}
+
+func TestSearchResponseRoundTripWithFiles(t *testing.T) {
+	target, _ := RandomID()
+	resp := &SearchResponseMessage{
+		Target: target,
+		Files: []ed2k.File{
+			{ClientID: 1, Port: 4662},
+			{ClientID: 2, Port: 4663},
+		},
+	}
+	data, err := resp.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	got := &SearchResponseMessage{}
+	if err := got.Decode(data); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Target != resp.Target || len(got.Files) != 2 {
+		t.Fatalf("got %+v, want %+v", got, resp)
+	}
+}
+
+func TestFirewalledRoundTrip(t *testing.T) {
+	resp := &FirewalledResponseMessage{Firewalled: true}
+	data, err := resp.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	got := &FirewalledResponseMessage{}
+	if err := got.Decode(data); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !got.Firewalled {
+		t.Fatalf("expected Firewalled to round-trip as true")
+	}
+}
@@ -0,0 +1,409 @@
+package kad
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eyedeekay/gmule-core/protocol/ed2k"
+)
+
+// Alpha is the parallelism factor for iterative node lookups, per the
+// standard Kademlia recommendation.
+const Alpha = 3
+
+// RefreshInterval is how often a bucket with no recent activity is
+// refreshed by looking up a random ID that falls within it.
+const RefreshInterval = time.Hour
+
+// DefaultRPCTimeout bounds how long Node waits for a response to a single
+// RPC before treating the contact as non-responsive.
+const DefaultRPCTimeout = 5 * time.Second
+
+// SearchResult is a single FIND_VALUE match: the file descriptor together
+// with the contact that returned it.
+type SearchResult struct {
+	File ed2k.File
+	From Contact
+}
+
+// Node is a participant in the Kad overlay: it owns a routing table and a
+// UDP socket, and can bootstrap from a seed, and perform iterative
+// FIND_VALUE keyword and source lookups.
+type Node struct {
+	ID      ID
+	TCPPort uint16
+
+	conn    *net.UDPConn
+	Routing *RoutingTable
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[ID]chan Message
+
+	closed chan struct{}
+}
+
+// NewNode creates a Node bound to conn with the given identity. Call
+// Bootstrap to join the network and Close to release the socket.
+func NewNode(conn *net.UDPConn, self ID, tcpPort uint16) *Node {
+	n := &Node{
+		ID:      self,
+		TCPPort: tcpPort,
+		conn:    conn,
+		Routing: NewRoutingTable(self),
+		Timeout: DefaultRPCTimeout,
+		pending: make(map[ID]chan Message),
+		closed:  make(chan struct{}),
+	}
+	go n.readLoop()
+	go n.refreshLoop()
+	return n
+}
+
+// Close stops the node's background read loop and closes its socket.
+func (n *Node) Close() error {
+	close(n.closed)
+	return n.conn.Close()
+}
+
+func (n *Node) readLoop() {
+	buf := make([]byte, 4096)
+	for {
+		size, addr, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		n.dispatch(addr, append([]byte{}, buf[:size]...))
+	}
+}
+
+func (n *Node) dispatch(addr *net.UDPAddr, data []byte) {
+	if len(data) < 2 || data[0] != Protocol {
+		return
+	}
+	var msg Message
+	switch data[1] {
+	case OpHelloResponse:
+		m := &HelloResponseMessage{}
+		if err := m.Decode(data); err != nil {
+			return
+		}
+		n.Routing.Insert(Contact{ID: m.ID, UDPAddr: addr, TCPPort: m.TCPPort})
+		msg = m
+	case OpBootstrapResponse:
+		m := &BootstrapResponseMessage{}
+		if err := m.Decode(data); err != nil {
+			return
+		}
+		n.Routing.Insert(Contact{ID: m.ID, UDPAddr: addr, TCPPort: m.TCPPort})
+		for _, c := range m.Contacts {
+			n.Routing.Insert(c)
+		}
+		msg = m
+	case OpFirewalledResponse:
+		m := &FirewalledResponseMessage{}
+		if err := m.Decode(data); err != nil {
+			return
+		}
+		msg = m
+	case OpSearchResponse:
+		m := &SearchResponseMessage{}
+		if err := m.Decode(data); err != nil {
+			return
+		}
+		for _, c := range m.Contacts {
+			n.Routing.Insert(c)
+		}
+		msg = m
+	case OpHelloRequest:
+		m := &HelloRequestMessage{}
+		if err := m.Decode(data); err != nil {
+			return
+		}
+		n.Routing.Insert(Contact{ID: m.ID, UDPAddr: addr, TCPPort: m.TCPPort})
+		resp := &HelloResponseMessage{ID: n.ID, TCPPort: n.TCPPort}
+		n.send(addr, resp)
+		return
+	case OpFirewalledRequest:
+		m := &FirewalledRequestMessage{}
+		if err := m.Decode(data); err != nil {
+			return
+		}
+		go n.answerFirewalledCheck(addr, m.TCPPort)
+		return
+	case OpBootstrapRequest:
+		m := &BootstrapRequestMessage{}
+		if err := m.Decode(data); err != nil {
+			return
+		}
+		resp := &BootstrapResponseMessage{
+			ID:       n.ID,
+			TCPPort:  n.TCPPort,
+			Contacts: n.Routing.Closest(n.ID, BucketSize),
+		}
+		n.send(addr, resp)
+		return
+	case OpSearchRequest:
+		m := &SearchRequestMessage{}
+		if err := m.Decode(data); err != nil {
+			return
+		}
+		resp := &SearchResponseMessage{
+			Target:   m.Target,
+			Contacts: n.Routing.Closest(m.Target, BucketSize),
+		}
+		n.send(addr, resp)
+		return
+	default:
+		return
+	}
+
+	// RPCs are correlated by remote address rather than by any ID carried
+	// in the message body: a node only has one request in flight to a
+	// given address at a time (lookup's Alpha-wide fan-out queries Alpha
+	// distinct contacts, never the same one twice in a round).
+	key := contactKeyForAddr(addr)
+	n.mu.Lock()
+	ch, ok := n.pending[key]
+	n.mu.Unlock()
+	if ok {
+		ch <- msg
+	}
+}
+
+// contactKeyForAddr derives the pending-request correlation key for addr.
+func contactKeyForAddr(addr *net.UDPAddr) ID {
+	var id ID
+	copy(id[:4], addr.IP.To4())
+	id[4] = byte(addr.Port)
+	id[5] = byte(addr.Port >> 8)
+	return id
+}
+
+func (n *Node) send(addr *net.UDPAddr, msg Message) error {
+	data, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	_, err = n.conn.WriteToUDP(data, addr)
+	return err
+}
+
+func (n *Node) call(addr *net.UDPAddr, req Message) (Message, error) {
+	key := contactKeyForAddr(addr)
+	ch := make(chan Message, 1)
+	n.mu.Lock()
+	n.pending[key] = ch
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		delete(n.pending, key)
+		n.mu.Unlock()
+	}()
+
+	if err := n.send(addr, req); err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-time.After(n.Timeout):
+		return nil, fmt.Errorf("kad: timed out waiting for response from %s", addr)
+	}
+}
+
+// Bootstrap seeds the routing table from a single known-good contact at
+// seedAddr: it sends a BOOTSTRAP request and inserts every contact the
+// seed returns.
+func (n *Node) Bootstrap(seedAddr *net.UDPAddr) error {
+	resp, err := n.call(seedAddr, &BootstrapRequestMessage{})
+	if err != nil {
+		return err
+	}
+	boot, ok := resp.(*BootstrapResponseMessage)
+	if !ok {
+		return fmt.Errorf("kad: unexpected bootstrap response type %T", resp)
+	}
+	n.Routing.Insert(Contact{ID: boot.ID, UDPAddr: seedAddr, TCPPort: boot.TCPPort})
+	for _, c := range boot.Contacts {
+		n.Routing.Insert(c)
+	}
+	return nil
+}
+
+// lookup performs an iterative Kademlia lookup for target, querying up to
+// Alpha contacts in parallel per round and converging on the k closest
+// contacts any queried peer knows about. kind selects FIND_NODE vs
+// FIND_VALUE; any Files a FIND_VALUE peer returns are appended to results.
+func (n *Node) lookup(target ID, kind SearchKind) (contacts []Contact, results []SearchResult, err error) {
+	queried := make(map[ID]bool)
+	shortlist := n.Routing.Closest(target, BucketSize)
+	if len(shortlist) == 0 {
+		return nil, nil, fmt.Errorf("kad: routing table is empty, bootstrap first")
+	}
+
+	for round := 0; round < NumBuckets; round++ {
+		var toQuery []Contact
+		for _, c := range shortlist {
+			if !queried[c.ID] {
+				toQuery = append(toQuery, c)
+			}
+			if len(toQuery) == Alpha {
+				break
+			}
+		}
+		if len(toQuery) == 0 {
+			break
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		for _, c := range toQuery {
+			queried[c.ID] = true
+			wg.Add(1)
+			go func(c Contact) {
+				defer wg.Done()
+				resp, err := n.call(c.UDPAddr, &SearchRequestMessage{Target: target, Kind: kind})
+				if err != nil {
+					return
+				}
+				search, ok := resp.(*SearchResponseMessage)
+				if !ok {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				shortlist = append(shortlist, search.Contacts...)
+				for _, f := range search.Files {
+					results = append(results, SearchResult{File: f, From: c})
+				}
+			}(c)
+		}
+		wg.Wait()
+		shortlist = closestUnique(target, shortlist, BucketSize)
+	}
+	return shortlist, results, nil
+}
+
+// closestUnique deduplicates contacts and returns the count closest to
+// target. Candidates are sorted by distance to target before being
+// inserted into the intermediate RoutingTable, since a bucket silently
+// drops a new contact once it is full (see bucket.upsert); inserting
+// closest-first ensures it is always a farther contact that gets
+// dropped, not a closer one.
+func closestUnique(target ID, contacts []Contact, count int) []Contact {
+	seen := make(map[ID]bool)
+	unique := make([]Contact, 0, len(contacts))
+	for _, c := range contacts {
+		if seen[c.ID] {
+			continue
+		}
+		seen[c.ID] = true
+		unique = append(unique, c)
+	}
+	sort.Slice(unique, func(i, j int) bool {
+		return Distance(target, unique[i].ID).Less(Distance(target, unique[j].ID))
+	})
+
+	rt := NewRoutingTable(target)
+	for _, c := range unique {
+		rt.Insert(c)
+	}
+	return rt.Closest(target, count)
+}
+
+// FindKeyword performs a FIND_VALUE lookup for hash (the Kad ID of a
+// keyword or file hash) and returns every matching file any queried
+// contact reports.
+func (n *Node) FindKeyword(hash ID) ([]SearchResult, error) {
+	_, results, err := n.lookup(hash, SearchFindValue)
+	return results, err
+}
+
+// FindSource performs a FIND_VALUE lookup for fileHash and returns the
+// (IP, port) of every source the queried contacts report for it, taken
+// from each matching File's ClientID and Port.
+func (n *Node) FindSource(fileHash ID) ([]net.UDPAddr, error) {
+	_, results, err := n.lookup(fileHash, SearchFindValue)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []net.UDPAddr
+	for _, r := range results {
+		addrs = append(addrs, net.UDPAddr{
+			IP:   net.IPv4(byte(r.File.ClientID), byte(r.File.ClientID>>8), byte(r.File.ClientID>>16), byte(r.File.ClientID>>24)),
+			Port: int(r.File.Port),
+		})
+	}
+	return addrs, nil
+}
+
+// CheckFirewalled asks addr, a peer already in the routing table, whether
+// it could open a direct TCP connection back to us on TCPPort, letting
+// this node detect whether it sits behind a firewall/NAT.
+func (n *Node) CheckFirewalled(addr *net.UDPAddr) (bool, error) {
+	resp, err := n.call(addr, &FirewalledRequestMessage{TCPPort: n.TCPPort})
+	if err != nil {
+		return false, err
+	}
+	fw, ok := resp.(*FirewalledResponseMessage)
+	if !ok {
+		return false, fmt.Errorf("kad: unexpected firewalled-check response type %T", resp)
+	}
+	return fw.Firewalled, nil
+}
+
+// answerFirewalledCheck serves a peer's FirewalledRequestMessage by trying
+// to dial it back on tcpPort: success means the peer is reachable and not
+// firewalled.
+func (n *Node) answerFirewalledCheck(addr *net.UDPAddr, tcpPort uint16) {
+	tcpAddr := &net.TCPAddr{IP: addr.IP, Port: int(tcpPort)}
+	conn, err := net.DialTimeout("tcp", tcpAddr.String(), n.Timeout)
+	firewalled := err != nil
+	if err == nil {
+		conn.Close()
+	}
+	n.send(addr, &FirewalledResponseMessage{Firewalled: firewalled})
+}
+
+// RefreshBucket performs a FIND_NODE lookup for a random ID that falls
+// within the k-bucket at index, inserting any newly discovered contacts
+// into the routing table.
+func (n *Node) RefreshBucket(index int) error {
+	target, err := RandomIDInBucket(n.ID, index)
+	if err != nil {
+		return err
+	}
+	contacts, _, err := n.lookup(target, SearchFindNode)
+	if err != nil {
+		return err
+	}
+	for _, c := range contacts {
+		n.Routing.Insert(c)
+	}
+	return nil
+}
+
+// RefreshStaleBuckets refreshes every bucket that has had no contact
+// inserted within RefreshInterval. refreshLoop calls this automatically.
+func (n *Node) RefreshStaleBuckets() {
+	for _, idx := range n.Routing.StaleBuckets(RefreshInterval) {
+		n.RefreshBucket(idx)
+	}
+}
+
+func (n *Node) refreshLoop() {
+	ticker := time.NewTicker(RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.RefreshStaleBuckets()
+		case <-n.closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package kad
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func newLoopbackNode(t *testing.T, id ID, tcpPort uint16) *Node {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	n := NewNode(conn, id, tcpPort)
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+func (n *Node) udpAddr() *net.UDPAddr {
+	return n.conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestNodeBootstrapPopulatesRoutingTable(t *testing.T) {
+	seedID, err := RandomID()
+	if err != nil {
+		t.Fatalf("RandomID: %v", err)
+	}
+	joinerID, err := RandomID()
+	if err != nil {
+		t.Fatalf("RandomID: %v", err)
+	}
+
+	seed := newLoopbackNode(t, seedID, 4662)
+	joiner := newLoopbackNode(t, joinerID, 4663)
+
+	if err := joiner.Bootstrap(seed.udpAddr()); err != nil {
+		t.Fatalf("Bootstrap: %v", err)
+	}
+
+	contacts := joiner.Routing.Contacts()
+	if len(contacts) != 1 || contacts[0].ID != seedID {
+		t.Fatalf("contacts = %+v, want exactly the seed %s", contacts, seedID)
+	}
+}
+
+func TestNodeLookupQueriesRemotePeer(t *testing.T) {
+	seedID, err := RandomID()
+	if err != nil {
+		t.Fatalf("RandomID: %v", err)
+	}
+	joinerID, err := RandomID()
+	if err != nil {
+		t.Fatalf("RandomID: %v", err)
+	}
+
+	seed := newLoopbackNode(t, seedID, 4662)
+	joiner := newLoopbackNode(t, joinerID, 4663)
+
+	if err := joiner.Bootstrap(seed.udpAddr()); err != nil {
+		t.Fatalf("Bootstrap: %v", err)
+	}
+
+	// RefreshBucket drives the same SEARCH RPC as FindKeyword/FindSource,
+	// against a target guaranteed to land in bucket 0. lookup drops a peer
+	// that does not answer after joiner.Timeout without reporting an error,
+	// so finishing well inside that timeout shows the seed answered.
+	start := time.Now()
+	if err := joiner.RefreshBucket(0); err != nil {
+		t.Fatalf("RefreshBucket: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= joiner.Timeout {
+		t.Fatalf("RefreshBucket took %v, want under the %v RPC timeout (seed did not answer)", elapsed, joiner.Timeout)
+	}
+}
+
+func TestNodeCheckFirewalledReachable(t *testing.T) {
+	aID, err := RandomID()
+	if err != nil {
+		t.Fatalf("RandomID: %v", err)
+	}
+	bID, err := RandomID()
+	if err != nil {
+		t.Fatalf("RandomID: %v", err)
+	}
+
+	// a.CheckFirewalled asks b to dial a back on a's claimed TCP port, so
+	// the listener that must be reachable belongs to a, not b.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	aTCPPort := uint16(ln.Addr().(*net.TCPAddr).Port)
+
+	a := newLoopbackNode(t, aID, aTCPPort)
+	b := newLoopbackNode(t, bID, 4663)
+	a.Timeout = time.Second
+	b.Timeout = time.Second
+
+	firewalled, err := a.CheckFirewalled(b.udpAddr())
+	if err != nil {
+		t.Fatalf("CheckFirewalled: %v", err)
+	}
+	if firewalled {
+		t.Fatalf("firewalled = true, want false (listener is reachable)")
+	}
+}
@@ -0,0 +1,96 @@
+package kad
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+// nodesDatVersion is written as eMule's nodes.dat format version marker.
+// Version 2 (no bucket-index header, just a flat contact list) is the
+// variant this package reads and writes.
+const nodesDatVersion = 2
+
+// maxPreallocContacts caps how many Contact slots LoadContacts will
+// pre-allocate based on the file's declared count, before it has read a
+// single entry. A corrupted or truncated nodes.dat could otherwise claim
+// a count of billions and force a multi-gigabyte allocation up front;
+// the loop below still reads (and rejects) as many entries as the file
+// actually contains beyond this hint.
+const maxPreallocContacts = 1 << 16
+
+// LoadContacts reads a nodes.dat-compatible contact list from path:
+// a uint32 version, a uint32 count, then count contacts of
+// ID(16)+IPv4(4)+UDPPort(2)+TCPPort(2)+version(1).
+func LoadContacts(path string) ([]Contact, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var version, count uint32
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, fmt.Errorf("kad: reading nodes.dat version: %w", err)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, fmt.Errorf("kad: reading nodes.dat count: %w", err)
+	}
+
+	prealloc := count
+	if prealloc > maxPreallocContacts {
+		prealloc = maxPreallocContacts
+	}
+	contacts := make([]Contact, 0, prealloc)
+	for i := uint32(0); i < count; i++ {
+		entry := make([]byte, contactWireSize+1)
+		if _, err := io.ReadFull(r, entry); err != nil {
+			return nil, fmt.Errorf("kad: reading nodes.dat entry %d: %w", i, err)
+		}
+		c, err := readContact(entry)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, c)
+	}
+	return contacts, nil
+}
+
+// SaveContacts writes contacts to path in nodes.dat-compatible form.
+func SaveContacts(path string, contacts []Contact) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if err := binary.Write(w, binary.LittleEndian, uint32(nodesDatVersion)); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(contacts))); err != nil {
+		return err
+	}
+	for _, c := range contacts {
+		ip := net.IPv4zero
+		port := 0
+		if c.UDPAddr != nil {
+			ip = c.UDPAddr.IP
+			port = c.UDPAddr.Port
+		}
+		w.Write(c.ID[:])
+		w.Write(ip.To4())
+		if err := binary.Write(w, binary.LittleEndian, uint16(port)); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, c.TCPPort); err != nil {
+			return err
+		}
+		w.WriteByte(nodesDatVersion)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,58 @@
+package kad
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveLoadContactsRoundTrip(t *testing.T) {
+	id1, _ := RandomID()
+	id2, _ := RandomID()
+	contacts := []Contact{
+		{ID: id1, UDPAddr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4672}, TCPPort: 4662},
+		{ID: id2, UDPAddr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4673}, TCPPort: 4663},
+	}
+
+	path := filepath.Join(t.TempDir(), "nodes.dat")
+	if err := SaveContacts(path, contacts); err != nil {
+		t.Fatalf("SaveContacts: %v", err)
+	}
+
+	got, err := LoadContacts(path)
+	if err != nil {
+		t.Fatalf("LoadContacts: %v", err)
+	}
+	if len(got) != len(contacts) {
+		t.Fatalf("len(got) = %d, want %d", len(got), len(contacts))
+	}
+	for i := range got {
+		if got[i].ID != contacts[i].ID || got[i].TCPPort != contacts[i].TCPPort {
+			t.Fatalf("contact %d = %+v, want %+v", i, got[i], contacts[i])
+		}
+		if !got[i].UDPAddr.IP.Equal(contacts[i].UDPAddr.IP) || got[i].UDPAddr.Port != contacts[i].UDPAddr.Port {
+			t.Fatalf("contact %d addr = %v, want %v", i, got[i].UDPAddr, contacts[i].UDPAddr)
+		}
+	}
+}
+
+func TestLoadContactsRejectsHugeDeclaredCountWithoutHugeAlloc(t *testing.T) {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, uint32(nodesDatVersion))
+	binary.Write(buf, binary.LittleEndian, uint32(0xFFFFFFFF)) // declared count, file has zero entries
+
+	path := filepath.Join(t.TempDir(), "nodes.dat")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// The declared count vastly exceeds the entries actually present, so
+	// reading the first entry must fail rather than succeed with garbage
+	// or hang allocating a multi-gigabyte slice up front.
+	if _, err := LoadContacts(path); err == nil {
+		t.Fatalf("LoadContacts of a file with a bogus huge count succeeded, want an error")
+	}
+}